@@ -1,37 +1,65 @@
 package analyze
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sgasse/finca/av"
+	"github.com/sgasse/finca/csvdata"
 	"github.com/sgasse/finca/sim"
+	"github.com/sgasse/finca/synth"
 )
 
 var (
-	DefaultFixedFees = 56.0
-	DefaultVarFees   = 0.015
-	FixedFees        = DefaultFixedFees
-	VarFees          = DefaultVarFees
+	DefaultSymbol = "SPY"
+	// Income paid into the portfolio on the first day of every month
+	DefaultMonthlyIncome = 1000.0
+	// Name of the result of the real portfolio built by the transactions of
+	// a scenario
+	ActualName = "Actual"
+	// Fees per fee type which the fee parameters of a request override.
+	// Without a type, these are the fixed fees of lump sums plus the variable
+	// fees of monthly investments.
+	DefaultFees = map[string]sim.FeeSpec{
+		"":           {Fixed: sim.LumpSumFees.Fixed, Var: sim.MonthlyFees.Var},
+		"flat":       {Type: "flat", Fixed: sim.LumpSumFees.Fixed},
+		"percentage": {Type: "percentage", Var: sim.MonthlyFees.Var},
+	}
 )
 
+// A PriceProvider is a `sim.PriceSource` which can also tell the range of
+// dates for which it holds data of a symbol.
+type PriceProvider interface {
+	sim.PriceSource
+	GetDateRange(string) (earliest, latest string, err error)
+}
+
+// A SeriesProvider provides all data points of a symbol, e.g. of the
+// consumer price index, by their dates as `2006-01-02`.
+type SeriesProvider interface {
+	GetSeries(string) (map[string]float64, error)
+}
+
 type SimResults struct {
 	Dates      []string
 	TimeSeries map[string][]float64
 	IRR        map[string]float64
+	Metrics    map[string]sim.Metrics
 }
 
-func evalSingleStockData(startDate time.Time, symbol string) (dates []string, timeSeries []float64, relChange []float64, maxDD []float64) {
+func evalSingleStockData(priceP PriceProvider, startDate time.Time, endDate time.Time, symbol string) (dates []string, timeSeries []float64, relChange []float64, maxDD []float64) {
 	curDay := startDate
-	for time.Now().Sub(curDay) > 0 {
-		price, err := av.GetPrice(symbol, curDay)
+	for endDate.Sub(curDay) >= 0 {
+		price, err := priceP.GetPrice(symbol, curDay)
 		if err == nil {
-			price := roundTo(2, price)
+			price := sim.RoundTo(2, price)
 			timeSeries = append(timeSeries, price)
 			dates = append(dates, curDay.Format("2006-01-02"))
 		}
@@ -39,6 +67,10 @@ func evalSingleStockData(startDate time.Time, symbol string) (dates []string, ti
 		curDay = curDay.Add(time.Duration(24 * time.Hour))
 	}
 
+	if len(timeSeries) == 0 {
+		return
+	}
+
 	lastMax := 0.0
 	// Change to first day equal to zero
 	lastPrice := timeSeries[0]
@@ -48,11 +80,11 @@ func evalSingleStockData(startDate time.Time, symbol string) (dates []string, ti
 			maxDD = append(maxDD, 0.0)
 			lastMax = price
 		} else {
-			drawdown := roundTo(2, (price/lastMax-1.0)*100)
+			drawdown := sim.RoundTo(2, (price/lastMax-1.0)*100)
 			maxDD = append(maxDD, drawdown)
 		}
 
-		percChange := roundTo(2, (price/lastPrice-1.0)*100)
+		percChange := sim.RoundTo(2, (price/lastPrice-1.0)*100)
 		relChange = append(relChange, percChange)
 		lastPrice = price
 	}
@@ -60,110 +92,821 @@ func evalSingleStockData(startDate time.Time, symbol string) (dates []string, ti
 	return
 }
 
-func getStartDate(symbol string) (sDate time.Time, err error) {
-	earliest, _, err := av.GetDateRange(symbol)
+// getDateRange determines the range of dates to simulate `symbol` on. The
+// start is the beginning of the first month after the earliest data point.
+// The end is the latest data point but not later than the current time given
+// by `Now`.
+func getDateRange(priceP PriceProvider, symbol string) (sDate time.Time, eDate time.Time, err error) {
+	earliest, latest, err := priceP.GetDateRange(symbol)
 	if err != nil {
 		return
 	}
 
-	sDate, err = time.Parse("2006-01-02", earliest)
+	sDate, err = parseDate(earliest)
 	if err != nil {
 		return
 	}
 
 	// Shift to beginning of next month
 	sDate = time.Date(sDate.Year(), sDate.Month()+1, 1, 12, 0, 0, 0, time.UTC)
+
+	eDate, err = parseDate(latest)
+	if err != nil {
+		return
+	}
+
+	now := Now()
+	if today := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.UTC); eDate.After(today) {
+		eDate = today
+	}
 	return
 }
 
-func addSimResult(simRes *SimResults, strat sim.Strategy, name string) error {
-	fixedFees := 0.0
-	varFees := 0.0
+// simDateRange determines the range of dates to simulate `symbol` on,
+// limited to the dates `from` and `to` if they are not empty.
+func simDateRange(priceP PriceProvider, symbol string, from string, to string) (sDate time.Time, eDate time.Time, err error) {
+	sDate, eDate, err = getDateRange(priceP, symbol)
+	if err != nil {
+		return
+	}
 
-	if FixedFees != DefaultFixedFees || VarFees != DefaultVarFees {
-		fixedFees = FixedFees
-		varFees = VarFees
-	} else if _, ok := strat.(*sim.MidMonth); ok {
-		varFees = DefaultVarFees
-	} else {
-		fixedFees = DefaultFixedFees
+	if from != "" {
+		fromDate, err := parseDate(from)
+		if err != nil {
+			return sDate, eDate, err
+		}
+		if fromDate.After(sDate) {
+			sDate = fromDate
+		}
+	}
+
+	if to != "" {
+		toDate, err := parseDate(to)
+		if err != nil {
+			return sDate, eDate, err
+		}
+		if toDate.Before(eDate) {
+			eDate = toDate
+		}
+	}
+
+	if sDate.After(eDate) {
+		err = errors.New(fmt.Sprint("No data for ", symbol, " between ",
+			sDate.Format("2006-01-02"), " and ", eDate.Format("2006-01-02")))
+	}
+	return
+}
+
+// portfolioDateRange determines the range of dates for which data of all
+// `symbols` is available, limited to the dates `from` and `to` if they are
+// not empty.
+func portfolioDateRange(priceP PriceProvider, symbols []string, from string, to string) (sDate time.Time, eDate time.Time, err error) {
+	for i, symbol := range symbols {
+		start, end, err := simDateRange(priceP, symbol, from, to)
+		if err != nil {
+			return sDate, eDate, err
+		}
+		if i == 0 || start.After(sDate) {
+			sDate = start
+		}
+		if i == 0 || end.Before(eDate) {
+			eDate = end
+		}
+	}
+
+	if sDate.After(eDate) {
+		err = errors.New(fmt.Sprint("No common data for ", strings.Join(symbols, ", "), " between ",
+			sDate.Format("2006-01-02"), " and ", eDate.Format("2006-01-02")))
+	}
+	return
+}
+
+// parseDate parses a date given as `2006-01-02` to noon UTC of this day, the
+// time of day all simulations run on.
+func parseDate(date string) (time.Time, error) {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return d, err
+	}
+	return d.Add(12 * time.Hour), nil
+}
+
+// ScenarioResult holds the outcome of simulating all strategies of a
+// scenario, keyed by the names of the strategies.
+type ScenarioResult struct {
+	Name string `json:"name,omitempty"`
+	// Reference symbol of drawdown strategies
+	Symbol    string           `json:"symbol"`
+	Portfolio []sim.Allocation `json:"portfolio"`
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	// Values are given in money of `From` as well
+	Real bool `json:"real,omitempty"`
+	// Currency of all values, empty if prices were not converted
+	Currency string `json:"currency,omitempty"`
+	// Prices at which trades were executed, empty for the close
+	Execution *sim.ExecutionSpec         `json:"execution,omitempty"`
+	Results   map[string]sim.StratResult `json:"results"`
+	start     time.Time
+	end       time.Time
+	// Names of the results in the order of the scenario
+	names []string
+}
+
+// Names returns the names of all results in the order of the strategies of
+// the scenario, followed by `ActualName` if real trades were evaluated.
+func (res ScenarioResult) Names() []string {
+	return res.names
+}
+
+// RunScenario simulates all strategies of `sc` with prices from `priceP`.
+// Fields missing in the scenario fall back to the default symbol, the full
+// range of data available for all symbols, the default income and the
+// default fees. The strategies are simulated concurrently by up to `Workers`
+// goroutines until `ctx` is done. If the scenario has transactions, the real
+// portfolio is evaluated as `ActualName` from its first trade on, unless the
+// scenario starts elsewhere.
+func RunScenario(ctx context.Context, priceP PriceProvider, sc sim.Scenario) (res ScenarioResult, err error) {
+	if err = sc.Validate(); err != nil {
+		return
+	}
+
+	var trades []sim.Trade
+	if sc.Transactions != "" {
+		if trades, err = csvdata.ReadTrades(sc.Transactions); err != nil {
+			return
+		}
+		if sc.From == "" {
+			sc.From = trades[0].Date.Format("2006-01-02")
+		}
+	}
+
+	var allocs []sim.Allocation
+	sc.Symbol, allocs = scenarioPortfolio(sc)
+	// Prices of the symbols traded are needed for the whole range as well
+	tradeAllocs := append(allocs[:len(allocs):len(allocs)], tradeAllocations(trades)...)
+	sDate, eDate, err := scenarioDateRange(priceP, sc, tradeAllocs)
+	if err != nil {
+		return
+	}
+
+	cal, err := scenarioCalendar(priceP, sc, allocs)
+	if err != nil {
+		return
+	}
+
+	res, err = runScenarioRange(ctx, priceP, sc, allocs, cal, sDate, eDate, Workers)
+	if err != nil || trades == nil {
+		return
+	}
+
+	if _, ok := res.Results[ActualName]; ok {
+		return res, fmt.Errorf("The strategy name %q is reserved for the real portfolio", ActualName)
+	}
+	if res.Results[ActualName], err = runActual(ctx, priceP, sc, trades, cal, sDate, eDate); err != nil {
+		return
 	}
+	res.names = append(res.names, ActualName)
+	return
+}
 
-	pValues, dates, irr := sim.SimulateStratOnRef(startDate, symbol, strat, fixedFees, varFees)
+// runActual evaluates the real portfolio built by `trades` in the setting of
+// `sc` on the trading days of `cal` from `sDate` to `eDate`.
+func runActual(ctx context.Context, priceP PriceProvider, sc sim.Scenario, trades []sim.Trade, cal sim.Calendar, sDate time.Time, eDate time.Time) (sim.StratResult, error) {
+	opts, cpi, err := scenarioOptions(priceP, sc)
+	if err != nil {
+		return sim.StratResult{}, err
+	}
 
-	if len(simRes.Dates) == 0 {
-		simRes.Dates = dates
-	} else if len(simRes.Dates) != len(dates) {
-		return errors.New("Simulation dates do not agree")
+	// Reinvestments of dividends are among the trades of the real portfolio
+	dividends := sc.Dividends
+	if dividends == sim.ReinvestDividends.String() {
+		dividends = sim.KeepDividends.String()
+	}
+	divOpt, err := stratDividends(sim.StrategySpec{}, dividends, priceP)
+	if err != nil {
+		return sim.StratResult{}, err
 	}
 
-	simRes.TimeSeries[name] = pValues
-	if irr >= 400.0 {
-		irr = 0
+	cfg := sim.SimConfig{
+		Start:    sDate,
+		End:      eDate,
+		PriceS:   priceP,
+		Options:  append(opts, divOpt),
+		CPI:      cpi,
+		Calendar: cal,
+	}
+	res, err := sim.SimulateTrades(ctx, cfg, trades)
+	if err != nil && err != ctx.Err() {
+		return res, fmt.Errorf("%s: %v", ActualName, err)
 	}
-	simRes.IRR[name] = irr
+	return res, err
+}
 
-	return nil
+// tradeAllocations returns an allocation without weight for every symbol of
+// `trades`.
+func tradeAllocations(trades []sim.Trade) (allocs []sim.Allocation) {
+	seen := make(map[string]bool)
+	for _, trade := range trades {
+		if !seen[trade.Symbol] {
+			seen[trade.Symbol] = true
+			allocs = append(allocs, sim.Allocation{Symbol: trade.Symbol, Currency: trade.Currency})
+		}
+	}
+	return
 }
 
-func addSimResults(simRes *SimResults, strats map[string]sim.Strategy) error {
-	for name, strat := range strats {
-		if err := addSimResult(simRes, strat, name); err != nil {
+// runScenarioRange simulates all strategies of `sc` on the portfolio
+// `allocs` on the trading days of `cal` from `sDate` to `eDate` on up to
+// `workers` goroutines.
+func runScenarioRange(ctx context.Context, priceP PriceProvider, sc sim.Scenario, allocs []sim.Allocation, cal sim.Calendar, sDate time.Time, eDate time.Time, workers int) (res ScenarioResult, err error) {
+	income := sim.IncomeSpec{Monthly: DefaultMonthlyIncome}
+	if sc.Income != nil {
+		income = *sc.Income
+	}
+
+	opts, cpi, err := scenarioOptions(priceP, sc)
+	if err != nil {
+		return
+	}
+
+	res = ScenarioResult{
+		Name:      sc.Name,
+		Real:      sc.Real,
+		Currency:  sc.Currency,
+		Execution: sc.Execution,
+		Symbol:    sc.Symbol,
+		Portfolio: allocs,
+		From:      sDate.Format("2006-01-02"),
+		To:        eDate.Format("2006-01-02"),
+		Results:   make(map[string]sim.StratResult),
+		start:     sDate,
+		end:       eDate,
+	}
+
+	// Every job builds its own strategy and portfolio so that no state is
+	// shared between the goroutines
+	env := sim.BuildEnv{Start: sDate, RefSymbol: sc.Symbol, PriceS: priceP}
+	results := make([]sim.StratResult, len(sc.Strategies))
+	err = runPool(ctx, workers, len(sc.Strategies), func(ctx context.Context, i int) error {
+		spec := sc.Strategies[i]
+		strat, err := spec.Build(env)
+		if err != nil {
 			return err
 		}
+
+		fees, err := spec.FeeModel(sc.Fees)
+		if err != nil {
+			return fmt.Errorf("%s: %v", spec.DisplayName(), err)
+		}
+
+		divOpt, err := stratDividends(spec, sc.Dividends, priceP)
+		if err != nil {
+			return fmt.Errorf("%s: %v", spec.DisplayName(), err)
+		}
+
+		cfg := sim.SimConfig{
+			Start:     sDate,
+			End:       eDate,
+			Portfolio: allocs,
+			PriceS:    priceP,
+			Income:    income,
+			Fees:      fees,
+			Options:   append(opts[:len(opts):len(opts)], divOpt),
+			CPI:       cpi,
+			Calendar:  cal,
+		}
+
+		if results[i], err = sim.SimulateStratOnRefContext(ctx, cfg, strat); err != nil {
+			if err == ctx.Err() {
+				return err
+			}
+			return fmt.Errorf("%s: %v", spec.DisplayName(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for i, spec := range sc.Strategies {
+		res.Results[spec.DisplayName()] = results[i]
+		res.names = append(res.names, spec.DisplayName())
 	}
-	return nil
+	return
+}
+
+// scenarioOptions returns the options of all portfolios simulated in `sc`
+// except for their dividends, and the consumer price index if values are
+// calculated in real terms.
+func scenarioOptions(priceP PriceProvider, sc sim.Scenario) (opts []sim.PortfolioOption, cpi *sim.CPISeries, err error) {
+	opts = []sim.PortfolioOption{sim.WithFractionalShares(sc.Fractional)}
+	if sc.Rebalance != nil {
+		opt, err := sc.Rebalance.Option()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if sc.Tax != nil {
+		opt, err := sc.Tax.Option()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if sc.Currency != "" {
+		opt, err := sim.CurrencyOption(priceP, sc.Currency)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if sc.Execution != nil {
+		opt, err := sim.ExecutionOption(priceP, *sc.Execution)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	if sc.Real {
+		if cpi, err = loadCPI(priceP); err != nil {
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// scenarioPortfolio returns the reference symbol and the portfolio of `sc`,
+// falling back to the default symbol.
+func scenarioPortfolio(sc sim.Scenario) (symbol string, allocs []sim.Allocation) {
+	symbol, allocs = sc.Symbol, sc.Portfolio
+	if len(allocs) == 0 {
+		if symbol == "" {
+			symbol = DefaultSymbol
+		}
+		allocs = []sim.Allocation{{Symbol: symbol, Weight: 1.0}}
+	}
+	if symbol == "" {
+		symbol = allocs[0].Symbol
+	}
+	return
+}
+
+// scenarioDateRange determines the range of dates of `sc` for which data of
+// the reference symbol, all symbols of `allocs` and the exchange rates to
+// convert them is available.
+func scenarioDateRange(priceP PriceProvider, sc sim.Scenario, allocs []sim.Allocation) (time.Time, time.Time, error) {
+	return portfolioDateRange(priceP, scenarioSymbols(sc, allocs), sc.From, sc.To)
+}
+
+// scenarioSymbols returns the reference symbol of `sc`, all symbols of
+// `allocs` and the symbols of the exchange rates to convert them.
+func scenarioSymbols(sc sim.Scenario, allocs []sim.Allocation) []string {
+	symbols := []string{sc.Symbol}
+	for _, alloc := range allocs {
+		symbols = append(symbols, alloc.Symbol)
+		if sc.Currency != "" && alloc.Currency != "" && alloc.Currency != sc.Currency {
+			symbols = append(symbols, sim.FXSymbol(alloc.Currency, sc.Currency))
+		}
+	}
+	return symbols
+}
+
+// scenarioCalendar returns the calendar of the days on which the reference
+// symbol of `sc` and all symbols of `allocs` have prices. Without price
+// series, the portfolio is traded on weekdays.
+func scenarioCalendar(priceP PriceProvider, sc sim.Scenario, allocs []sim.Allocation) (sim.Calendar, error) {
+	seriesP, ok := priceP.(SeriesProvider)
+	if !ok {
+		return sim.WeekdayCalendar{}, nil
+	}
+
+	symbols := []string{sc.Symbol}
+	for _, alloc := range allocs {
+		symbols = append(symbols, alloc.Symbol)
+	}
+
+	var series []map[string]float64
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		if seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+
+		values, err := seriesP.GetSeries(symbol)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, values)
+	}
+	return sim.NewSeriesCalendar(series...), nil
+}
+
+// stratDividends returns the option handling dividends of the strategy of
+// `spec`. The dividends of the spec take precedence over the dividends of the
+// scenario.
+func stratDividends(spec sim.StrategySpec, scenarioDividends string, priceP PriceProvider) (sim.PortfolioOption, error) {
+	name := scenarioDividends
+	if spec.Dividends != "" {
+		name = spec.Dividends
+	}
+	mode, err := sim.ParseDividendMode(name)
+	if err != nil {
+		return nil, err
+	}
+	return sim.DividendOption(priceP, mode)
+}
+
+// loadCPI loads the consumer price index of the symbol `av.CPISymbol` from
+// `priceP`.
+func loadCPI(priceP PriceProvider) (*sim.CPISeries, error) {
+	seriesP, ok := priceP.(SeriesProvider)
+	if !ok {
+		return nil, errors.New("The data source provides no consumer price index")
+	}
+
+	values, err := seriesP.GetSeries(av.CPISymbol)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the consumer price index: %v", err)
+	}
+
+	points := make(map[time.Time]float64, len(values))
+	for date, value := range values {
+		d, err := parseDate(date)
+		if err != nil {
+			return nil, err
+		}
+		points[d] = value
+	}
+	return sim.NewCPISeries(points)
+}
+
+// title describes the portfolio of the result for charts.
+func (res ScenarioResult) title() string {
+	title := sim.FormatAllocations(res.Portfolio)
+	if res.Currency != "" {
+		title += " in " + res.Currency
+	}
+	if res.Real {
+		title += " in money of " + res.From
+	}
+	return title + executionTitle(res.Execution)
+}
+
+// executionTitle describes the execution of trades of `spec` for charts,
+// empty for trades at the close without slippage.
+func executionTitle(spec *sim.ExecutionSpec) string {
+	if spec == nil || *spec == (sim.ExecutionSpec{}) {
+		return ""
+	}
+	return ", trading at " + spec.String()
+}
+
+// simResults collects the results of all strategies for charts. Real values
+// and metrics are used if the scenario was simulated with them.
+func (res ScenarioResult) simResults() (SimResults, error) {
+	simRes := newSimRes()
+	for name, stratRes := range res.Results {
+		if len(simRes.Dates) == 0 {
+			simRes.Dates = stratRes.Dates
+		} else if len(simRes.Dates) != len(stratRes.Dates) {
+			return simRes, errors.New("Simulation dates do not agree")
+		}
+
+		simRes.TimeSeries[name] = stratRes.Values
+		simRes.IRR[name] = stratRes.IRR
+		simRes.Metrics[name] = stratRes.Metrics
+		if res.Real && stratRes.RealMetrics != nil {
+			simRes.TimeSeries[name] = stratRes.RealValues
+			simRes.IRR[name] = stratRes.RealIRR
+			simRes.Metrics[name] = *stratRes.RealMetrics
+		}
+	}
+	return simRes, nil
 }
 
 func newSimRes() SimResults {
 	return SimResults{
 		TimeSeries: make(map[string][]float64),
 		IRR:        make(map[string]float64),
+		Metrics:    make(map[string]sim.Metrics),
 	}
 }
 
-func maybeSetParams(r *http.Request) error {
+// scenarioFromParams creates a scenario without strategies from the query
+// parameters `symbol`, `symbols`, `from`, `to`, `rebalance`, `band`,
+// `fractional`, `real`, `currency`, `dividends`, `execution`, `slippage` and
+// `transactions` of a request. `symbols` is a weighted list like
+// `VTI@USD:0.6,BND:0.4`, `transactions` the name of a file in
+// `TransactionDir`. The fees are read by `feesFromParams`, the taxes by
+// `taxFromParams` and the income by `incomeFromParams`. Invalid parameters
+// are returned as `badRequest`.
+func scenarioFromParams(r *http.Request) (sc sim.Scenario, err error) {
+	defer func() {
+		if err != nil {
+			err = badRequest{err}
+		}
+	}()
+
 	params, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		return err
+		return
 	}
 	log.Println("Got params: ", params)
 
-	prevSymbol := symbol
-	inSym, ok := params["symbol"]
-	if ok {
-		symbol = inSym[0]
+	sc.Symbol = params.Get("symbol")
+	if list := params.Get("symbols"); list != "" {
+		if sc.Portfolio, err = sim.ParseAllocations(list); err != nil {
+			return
+		}
 	}
+	sc.From = params.Get("from")
+	sc.To = params.Get("to")
 
-	sDate, err := getStartDate(symbol)
-	if err != nil {
-		symbol = prevSymbol
-		return err
+	if sc.Fees, err = feesFromParams(params); err != nil {
+		return
+	}
+
+	if mode := params.Get("rebalance"); mode != "" {
+		sc.Rebalance = &sim.RebalanceSpec{Mode: mode}
+		if param, ok := params["band"]; ok {
+			if sc.Rebalance.Band, err = strconv.ParseFloat(param[0], 64); err != nil {
+				return
+			}
+		}
+		if _, err = sc.Rebalance.Option(); err != nil {
+			return
+		}
+	}
+
+	if param, ok := params["fractional"]; ok {
+		if sc.Fractional, err = strconv.Atoi(param[0]); err != nil {
+			return
+		}
+	}
+
+	if param, ok := params["real"]; ok {
+		if sc.Real, err = strconv.ParseBool(param[0]); err != nil {
+			return
+		}
 	}
 
-	startDate = sDate
+	sc.Currency = params.Get("currency")
+	if err = sim.ValidateCurrency(sc.Currency); err != nil {
+		return
+	}
+
+	sc.Dividends = params.Get("dividends")
+	if _, err = sim.ParseDividendMode(sc.Dividends); err != nil {
+		return
+	}
+
+	if sc.Execution, err = executionFromParams(params); err != nil {
+		return
+	}
+
+	if name := params.Get("transactions"); name != "" {
+		if sc.Transactions, err = transactionsPath(name); err != nil {
+			return
+		}
+	}
+
+	if sc.Tax, err = taxFromParams(params); err != nil {
+		return
+	}
+
+	sc.Income, err = incomeFromParams(params)
+	return
+}
 
-	param, cFees := params["fixedFees"]
-	if cFees {
-		fFees, err := strconv.ParseFloat(param[0], 64)
+// taxFromParams creates a tax spec from the query parameters `gainsTax`,
+// `dividendTax`, `withdrawalTax`, `taxAllowance`, `lots` and `account`.
+// Without any of the parameters, no taxes are paid.
+func taxFromParams(params url.Values) (*sim.TaxSpec, error) {
+	tax := &sim.TaxSpec{Lots: params.Get("lots"), Account: params.Get("account")}
+	found := tax.Lots != "" || tax.Account != ""
+	for key, field := range map[string]*float64{
+		"gainsTax":      &tax.CapitalGains,
+		"dividendTax":   &tax.Dividends,
+		"withdrawalTax": &tax.Withdrawals,
+		"taxAllowance":  &tax.Allowance,
+	} {
+		param, ok := params[key]
+		if !ok {
+			continue
+		}
+		found = true
+
+		var err error
+		if *field, err = strconv.ParseFloat(param[0], 64); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	if _, err := tax.Option(); err != nil {
+		return nil, err
+	}
+	return tax, nil
+}
+
+// executionFromParams creates an execution spec from the query parameters
+// `execution` and `slippage`. Without either, trades are executed at the
+// close.
+func executionFromParams(params url.Values) (*sim.ExecutionSpec, error) {
+	_, found := params["slippage"]
+	exec := &sim.ExecutionSpec{Price: params.Get("execution")}
+	if exec.Price == "" && !found {
+		return nil, nil
+	}
+
+	if found {
+		var err error
+		if exec.Slippage, err = strconv.ParseFloat(params.Get("slippage"), 64); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := exec.Model(); err != nil {
+		return nil, err
+	}
+	return exec, nil
+}
+
+// monteCarloFromParams creates the spec of a Monte Carlo simulation from the
+// query parameters `model`, `block`, `alpha`, `beta`, `paths`, `years` and
+// `seed`. Parameters not given fall back to their defaults.
+func monteCarloFromParams(params url.Values) (mc MonteCarloSpec, err error) {
+	mc.Returns.Model = params.Get("model")
+	for key, field := range map[string]*int{
+		"block": &mc.Returns.Block,
+		"paths": &mc.Paths,
+		"years": &mc.Years,
+	} {
+		if param, ok := params[key]; ok {
+			if *field, err = strconv.Atoi(param[0]); err != nil {
+				return
+			}
+		}
+	}
+	for key, field := range map[string]*float64{
+		"alpha": &mc.Returns.Alpha,
+		"beta":  &mc.Returns.Beta,
+	} {
+		if param, ok := params[key]; ok {
+			if *field, err = strconv.ParseFloat(param[0], 64); err != nil {
+				return
+			}
+		}
+	}
+	if param, ok := params["seed"]; ok {
+		if mc.Seed, err = strconv.ParseInt(param[0], 10, 64); err != nil {
+			return
+		}
+	}
+
+	if _, err = synth.ParseModel(mc.Returns.Model); err != nil {
+		return
+	}
+	err = mc.Validate()
+	return
+}
+
+// feesFromParams creates a fee spec from the query parameters `feeType`,
+// `fixedFees`, `varFees`, `minFees` and `maxFees`. The given parameters
+// override the `DefaultFees` of the fee type. With a minimum or maximum but no
+// type, the type is `percentage`. Tiered fees can only be given in scenario
+// files. Without any of the parameters, the default fees of the strategies
+// are used.
+func feesFromParams(params url.Values) (*sim.FeeSpec, error) {
+	feeType := params.Get("feeType")
+	if feeType == "" && (params.Get("minFees") != "" || params.Get("maxFees") != "") {
+		feeType = "percentage"
+	}
+	fees := &sim.FeeSpec{Type: feeType}
+	if defaults, ok := DefaultFees[feeType]; ok {
+		*fees = defaults
+	}
+
+	found := feeType != ""
+	for key, field := range map[string]*float64{
+		"fixedFees": &fees.Fixed,
+		"varFees":   &fees.Var,
+		"minFees":   &fees.Min,
+		"maxFees":   &fees.Max,
+	} {
+		param, ok := params[key]
+		if !ok {
+			continue
+		}
+		found = true
+
+		var err error
+		if *field, err = strconv.ParseFloat(param[0], 64); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	if _, err := fees.Model(); err != nil {
+		return nil, err
+	}
+	return fees, nil
+}
+
+// incomeFromParams creates an income spec from the query parameters
+// `income`, `raise`, `inflation`, `bonus`, `lump` and `pause`. Bonuses are
+// given as `month:amount`, lump sums as `2006-01-02:amount` and pauses as
+// `2006-01-02:2006-01-02`. These may be repeated or separated by commas.
+// Without any of the parameters, the default income is used.
+func incomeFromParams(params url.Values) (*sim.IncomeSpec, error) {
+	found := false
+	for _, key := range []string{"income", "raise", "inflation", "bonus", "lump", "pause"} {
+		if _, ok := params[key]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	var err error
+	inc := &sim.IncomeSpec{Monthly: DefaultMonthlyIncome}
+	if param, ok := params["income"]; ok {
+		if inc.Monthly, err = strconv.ParseFloat(param[0], 64); err != nil {
+			return nil, err
+		}
+	}
+	if param, ok := params["raise"]; ok {
+		if inc.Raise, err = strconv.ParseFloat(param[0], 64); err != nil {
+			return nil, err
+		}
+	}
+	if param, ok := params["inflation"]; ok {
+		if inc.Inflation, err = strconv.ParseFloat(param[0], 64); err != nil {
+			return nil, err
+		}
+	}
+
+	bonuses, err := listParam(params, "bonus")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range bonuses {
+		month, err := strconv.Atoi(pair[0])
 		if err != nil {
-			return err
+			return nil, err
+		}
+		amount, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			return nil, err
 		}
-		FixedFees = fFees
+		inc.Bonuses = append(inc.Bonuses, sim.BonusSpec{Month: month, Amount: amount})
 	}
 
-	param, cFees = params["varFees"]
-	if cFees {
-		vFees, err := strconv.ParseFloat(param[0], 64)
+	lumps, err := listParam(params, "lump")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range lumps {
+		amount, err := strconv.ParseFloat(pair[1], 64)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		VarFees = vFees
+		inc.LumpSums = append(inc.LumpSums, sim.LumpSumSpec{Date: pair[0], Amount: amount})
 	}
-	return nil
+
+	pauses, err := listParam(params, "pause")
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pauses {
+		inc.Pauses = append(inc.Pauses, sim.PauseSpec{From: pair[0], To: pair[1]})
+	}
+
+	if _, err := inc.Build(time.Time{}); err != nil {
+		return nil, err
+	}
+	return inc, nil
 }
 
-func roundTo(digits float64, number float64) float64 {
-	factor := math.Pow(10, digits)
-	return math.Round(number*factor) / factor
+// listParam splits all values of the query parameter `key` at commas into
+// pairs separated by a colon. Values without a colon are an error.
+func listParam(params url.Values, key string) (pairs [][2]string, err error) {
+	for _, param := range params[key] {
+		for _, item := range strings.Split(param, ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid %s %q, expected two values separated by a colon", key, item)
+			}
+			pairs = append(pairs, [2]string{parts[0], parts[1]})
+		}
+	}
+	return
 }
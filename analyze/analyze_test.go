@@ -0,0 +1,138 @@
+package analyze
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgasse/finca/sim"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPriceProvider provides prices of every symbol from 2010 to 2015 which
+// swing between 50 and 150 every few years, so that drawdown strategies
+// invest as well.
+type mockPriceProvider struct{}
+
+func (mockPriceProvider) GetPrice(symbol string, date time.Time) (float64, error) {
+	days := date.Sub(time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)).Hours() / 24
+	return 100.0 * (1.0 + 0.5*math.Sin(days/150.0)), nil
+}
+
+func (mockPriceProvider) GetDateRange(symbol string) (string, string, error) {
+	return "2010-01-04", "2015-12-31", nil
+}
+
+func TestRunScenarioConcurrently(t *testing.T) {
+	defer func(workers int) { Workers = workers }(Workers)
+
+	sc := sim.Scenario{
+		Portfolio:  []sim.Allocation{{Symbol: "A", Weight: 0.6}, {Symbol: "B", Weight: 0.4}},
+		Strategies: CompareSpecs,
+	}
+
+	Workers = 1
+	sequential, err := RunScenario(context.Background(), mockPriceProvider{}, sc)
+	assert.Nil(t, err)
+	assert.Len(t, sequential.Results, len(CompareSpecs))
+
+	// Every strategy is simulated on its own portfolio, so running them
+	// concurrently gives the same results
+	Workers = 4
+	concurrent, err := RunScenario(context.Background(), mockPriceProvider{}, sc)
+	assert.Nil(t, err)
+	assert.Equal(t, sequential.Results, concurrent.Results)
+	assert.NotEqual(t, sequential.Results["Monthly"].FinalValue, sequential.Results["30%Drawdown"].FinalValue,
+		"Strategies should trade differently")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RunScenario(ctx, mockPriceProvider{}, sc)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestOverrideScenario(t *testing.T) {
+	sc := sim.Scenario{Fractional: 4, Real: true, From: "2010-01-01"}
+
+	// Parameters which are not given keep the values of the scenario
+	r := httptest.NewRequest("GET", "/scenario?name=test&to=2015-12-31", nil)
+	params, err := scenarioFromParams(r)
+	assert.Nil(t, err)
+	overrideScenario(&sc, params, r.URL.Query())
+	assert.Equal(t, sim.Scenario{Fractional: 4, Real: true, From: "2010-01-01", To: "2015-12-31"}, sc)
+
+	// Given parameters reset them to their zero values
+	r = httptest.NewRequest("GET", "/scenario?name=test&fractional=0&real=false", nil)
+	params, err = scenarioFromParams(r)
+	assert.Nil(t, err)
+	overrideScenario(&sc, params, r.URL.Query())
+	assert.Equal(t, 0, sc.Fractional, "Expected whole shares")
+	assert.False(t, sc.Real, "Expected nominal values")
+	assert.Equal(t, "2010-01-01", sc.From)
+}
+
+func TestFeesFromParams(t *testing.T) {
+	fees, err := feesFromParams(url.Values{})
+	assert.Nil(t, err)
+	assert.Nil(t, fees, "Expected the default fees of the strategies")
+
+	// A single parameter keeps the other default fees
+	fees, err = feesFromParams(url.Values{"varFees": {"0.01"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &sim.FeeSpec{Fixed: 56.0, Var: 0.01}, fees)
+
+	fees, err = feesFromParams(url.Values{"fixedFees": {"10"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &sim.FeeSpec{Fixed: 10.0, Var: 0.015}, fees)
+
+	fees, err = feesFromParams(url.Values{"minFees": {"5"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &sim.FeeSpec{Type: "percentage", Var: 0.015, Min: 5.0}, fees)
+
+	fees, err = feesFromParams(url.Values{"feeType": {"savingsPlan"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &sim.FeeSpec{Type: "savingsPlan"}, fees)
+}
+
+func TestIncomeListParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "/compare?income=1000&bonus=12:5000&lump=2012-01-02:20000&pause=2013-01-01:2013-06-30", nil)
+	sc, err := scenarioFromParams(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []sim.BonusSpec{{Month: 12, Amount: 5000}}, sc.Income.Bonuses)
+	assert.Equal(t, []sim.LumpSumSpec{{Date: "2012-01-02", Amount: 20000}}, sc.Income.LumpSums)
+	assert.Equal(t, []sim.PauseSpec{{From: "2013-01-01", To: "2013-06-30"}}, sc.Income.Pauses)
+
+	// Items without a colon are answered with the status 400
+	for _, query := range []string{"bonus=12", "lump=2012-01-02", "pause=x", "bonus=12:5000,6"} {
+		r = httptest.NewRequest("GET", "/compare?"+query, nil)
+		w := httptest.NewRecorder()
+		chartHandler(compareStrats).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestSimulateAPIBodyLimit(t *testing.T) {
+	body := strings.Repeat(" ", int(MaxRequestBytes)+1)
+	r := httptest.NewRequest("POST", "/api/simulate", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	apiHandler(simulateAPI).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "too large")
+}
+
+func TestOutcomes(t *testing.T) {
+	scRes := ScenarioResult{Results: map[string]sim.StratResult{
+		"A": {IRR: 5.0, FinalValue: 2000.0, RealIRR: 3.0, RealFinalValue: 1500.0, Values: []float64{1000.0, 2000.0},
+			Ledger: []sim.LedgerEntry{{Type: "income"}}},
+	}}
+
+	assert.Equal(t, map[string]outcome{"A": {irr: 5.0, finalValue: 2000.0}}, outcomes(scRes, false, false),
+		"Only the IRR and final value should be kept")
+	assert.Equal(t, map[string]outcome{"A": {irr: 3.0, finalValue: 1500.0}}, outcomes(scRes, true, false))
+	assert.Equal(t, []float64{1000.0, 2000.0}, outcomes(scRes, false, true)["A"].values)
+}
@@ -0,0 +1,69 @@
+package analyze
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sgasse/finca/sim"
+)
+
+// MaxRequestBytes limits the size of the body of API requests, which is read
+// into memory as a whole.
+var MaxRequestBytes int64 = 1 << 20
+
+// An apiHandler wraps a HTTP handler returning a value which is encoded as
+// JSON response. Errors are returned as JSON object with the error message
+// and the given HTTP status code. Bodies larger than `MaxRequestBytes` can
+// not be read.
+type apiHandler func(*http.Request) (interface{}, int, error)
+
+// ServeHTTP makes the apiHandler interface implement `http.Handler`.
+func (fn apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBytes)
+
+	res, status, err := fn(r)
+	if err != nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// simulateAPI runs the scenario posted as JSON. The scenario has the same
+// format as scenario files, except that `transactions` is the name of a file
+// in `TransactionDir`.
+func simulateAPI(r *http.Request) (interface{}, int, error) {
+	if r.Method != http.MethodPost {
+		return nil, http.StatusMethodNotAllowed, errors.New("Only POST is supported")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	sc, err := sim.ParseScenario(body, true)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// Only transactions of `TransactionDir` can be read
+	if sc.Transactions != "" {
+		if sc.Transactions, err = transactionsPath(sc.Transactions); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	res, err := RunScenario(r.Context(), priceP, sc)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return res, http.StatusOK, nil
+}
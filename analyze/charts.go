@@ -42,6 +42,21 @@ func multiSeriesChart(symbol string, name string, dates []string, series interfa
 	return templateChart(data, tplFile)
 }
 
+// outcomeChart renders the result of a rolling backtest or a Monte Carlo
+// simulation.
+func outcomeChart(symbol string, name string, res interface{}, tplFile string) (template.HTML, error) {
+	data := struct {
+		Symbol string
+		Name   string
+		Result interface{}
+	}{
+		Symbol: symbol,
+		Name:   name,
+		Result: res,
+	}
+	return templateChart(data, tplFile)
+}
+
 func templateChart(data interface{}, tplFile string) (template.HTML, error) {
 	t, err := template.ParseFiles(tplFile)
 	if err != nil {
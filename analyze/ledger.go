@@ -0,0 +1,129 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/sgasse/finca/sim"
+)
+
+// A StrategyLedger holds every transaction of a strategy in a simulation.
+type StrategyLedger struct {
+	Strategy     string            `json:"strategy"`
+	Transactions []sim.LedgerEntry `json:"transactions"`
+}
+
+// LedgerHeader names the columns of the CSV export of ledgers.
+var LedgerHeader = []string{"strategy", "date", "executed", "type", "symbol", "shares", "price", "fees", "amount", "cash"}
+
+// ScenarioLedgers returns the ledgers of all results of `res` in the order
+// of `res.Names`. If `strategy` is not empty, only the ledger of the strategy
+// with this name is returned.
+func ScenarioLedgers(res ScenarioResult, strategy string) ([]StrategyLedger, error) {
+	var ledgers []StrategyLedger
+	for _, name := range res.Names() {
+		if strategy != "" && name != strategy {
+			continue
+		}
+		ledgers = append(ledgers, StrategyLedger{Strategy: name, Transactions: res.Results[name].Ledger})
+	}
+	if strategy != "" && len(ledgers) == 0 {
+		return nil, fmt.Errorf("Unknown strategy %q", strategy)
+	}
+	return ledgers, nil
+}
+
+// LedgerRecords returns a record with the columns of `LedgerHeader` for
+// every transaction of `ledgers`.
+func LedgerRecords(ledgers []StrategyLedger) (records [][]string) {
+	for _, ledger := range ledgers {
+		for _, entry := range ledger.Transactions {
+			records = append(records, []string{
+				ledger.Strategy,
+				entry.Date,
+				entry.Executed,
+				entry.Type,
+				entry.Symbol,
+				formatNonZero(entry.Shares, -1),
+				formatNonZero(entry.Price, 4),
+				strconv.FormatFloat(entry.Fees, 'f', 2, 64),
+				strconv.FormatFloat(entry.Amount, 'f', 2, 64),
+				strconv.FormatFloat(entry.Cash, 'f', 2, 64),
+			})
+		}
+	}
+	return
+}
+
+// formatNonZero formats `value` with `prec` decimal places, leaving it empty
+// if it is 0 like the fields omitted in JSON.
+func formatNonZero(value float64, prec int) string {
+	if value == 0.0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', prec, 64)
+}
+
+// WriteLedgerCSV writes all transactions of `ledgers` as CSV with the
+// columns of `LedgerHeader`.
+func WriteLedgerCSV(w io.Writer, ledgers []StrategyLedger) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(LedgerHeader); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(LedgerRecords(ledgers)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// runLedgers simulates the strategies of `/compare` or of the scenario file
+// given by the query parameter `name`, overridden with the query parameters
+// of `/scenario`, and returns their ledgers. The query parameter `strategy`
+// limits them to one strategy.
+func runLedgers(r *http.Request) ([]StrategyLedger, error) {
+	sc, err := compareOrNamedScenario(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := RunScenario(r.Context(), priceP, sc)
+	if err != nil {
+		return nil, err
+	}
+	return ScenarioLedgers(res, r.URL.Query().Get("strategy"))
+}
+
+// ledger downloads the transactions of all strategies as CSV, see
+// `runLedgers`.
+func ledger(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		ledgers, err := runLedgers(r)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="ledger.csv"`)
+		return WriteLedgerCSV(w, ledgers)
+	}
+	return nil
+}
+
+// ledgerAPI returns the transactions of all strategies as JSON, see
+// `runLedgers`.
+func ledgerAPI(r *http.Request) (interface{}, int, error) {
+	if r.Method != http.MethodGet {
+		return nil, http.StatusMethodNotAllowed, errors.New("Only GET is supported")
+	}
+
+	ledgers, err := runLedgers(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return ledgers, http.StatusOK, nil
+}
@@ -0,0 +1,241 @@
+package analyze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sgasse/finca/sim"
+	"github.com/sgasse/finca/synth"
+)
+
+var (
+	// DefaultPaths is the number of price paths of Monte Carlo simulations.
+	DefaultPaths = 1000
+	// DefaultMonteCarloYears is the length of the paths in years.
+	DefaultMonteCarloYears = 10
+	// MaxPaths and MaxMonteCarloYears limit the memory and time a single
+	// Monte Carlo simulation can take. The monthly values of all paths are
+	// kept for the fans, at most 5000 paths of 600 months or 24 MB per
+	// strategy.
+	MaxPaths           = 5000
+	MaxMonteCarloYears = 50
+)
+
+// A MonteCarloSpec describes the synthetic price paths of a Monte Carlo
+// simulation. Fields not given fall back to their defaults.
+type MonteCarloSpec struct {
+	// Model of the returns of the paths
+	Returns synth.Spec
+	Paths   int
+	// Length of the paths in years
+	Years int
+	// Seed of the first path, the following paths use the next seeds
+	Seed int64
+}
+
+// Validate checks that the number of paths and years is within the limits
+// `MaxPaths` and `MaxMonteCarloYears`. Zero stands for the defaults.
+func (mc MonteCarloSpec) Validate() error {
+	if mc.Paths < 0 || mc.Years < 0 {
+		return errors.New("Number of paths and years must be positive")
+	}
+	if mc.Paths > MaxPaths {
+		return fmt.Errorf("Number of paths %d above the limit of %d", mc.Paths, MaxPaths)
+	}
+	if mc.Years > MaxMonteCarloYears {
+		return fmt.Errorf("Length of paths of %d years above the limit of %d", mc.Years, MaxMonteCarloYears)
+	}
+	return nil
+}
+
+// A Fan holds percentiles of the portfolio values over all paths on every
+// evaluation date.
+type Fan struct {
+	P5     []float64 `json:"p5"`
+	P25    []float64 `json:"p25"`
+	Median []float64 `json:"median"`
+	P75    []float64 `json:"p75"`
+	P95    []float64 `json:"p95"`
+}
+
+// MonteCarloResult holds the outcome of simulating all strategies of a
+// scenario on synthetic price paths, keyed by the names of the strategies.
+type MonteCarloResult struct {
+	Name      string             `json:"name,omitempty"`
+	Symbol    string             `json:"symbol"`
+	Portfolio []sim.Allocation   `json:"portfolio"`
+	Currency  string             `json:"currency,omitempty"`
+	Execution *sim.ExecutionSpec `json:"execution,omitempty"`
+	Model     string             `json:"model"`
+	Paths     int                `json:"paths"`
+	Seed      int64              `json:"seed"`
+	// Range of the historic prices the model was fitted to
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Strategy the win rates are relative to
+	Baseline string `json:"baseline"`
+	// Dates of evaluation of the paths in the format `2006/01/02`
+	Dates []string                `json:"dates"`
+	Fans  map[string]Fan          `json:"fans"`
+	Stats map[string]OutcomeStats `json:"stats"`
+}
+
+// RunMonteCarlo simulates all strategies of `sc` on synthetic price paths
+// starting in the month after the date range of the scenario. The returns of
+// the paths are modeled after the historic prices of all symbols of the
+// scenario within its date range, which have to be provided as series by
+// `priceP`. Win rates are relative to the strategy named `baseline` like in
+// `RunRolling`. Paths are simulated concurrently by up to `Workers`
+// goroutines until `ctx` is done.
+func RunMonteCarlo(ctx context.Context, priceP PriceProvider, sc sim.Scenario, mc MonteCarloSpec, baseline string) (res MonteCarloResult, err error) {
+	if mc.Paths == 0 {
+		mc.Paths = DefaultPaths
+	}
+	if mc.Years == 0 {
+		mc.Years = DefaultMonteCarloYears
+	}
+	if err = mc.Validate(); err != nil {
+		return
+	}
+	if sc.Real {
+		return res, errors.New("Real values are not supported on synthetic prices")
+	}
+	if err = sc.Validate(); err != nil {
+		return
+	}
+	if baseline, err = baselineName(sc.Strategies, baseline); err != nil {
+		return
+	}
+
+	var allocs []sim.Allocation
+	sc.Symbol, allocs = scenarioPortfolio(sc)
+	sDate, eDate, err := scenarioDateRange(priceP, sc, allocs)
+	if err != nil {
+		return
+	}
+
+	h, err := loadHistory(priceP, scenarioSymbols(sc, allocs), sDate, eDate)
+	if err != nil {
+		return
+	}
+	gen, err := mc.Returns.Generator(h)
+	if err != nil {
+		return
+	}
+	model, _ := synth.ParseModel(mc.Returns.Model)
+
+	res = MonteCarloResult{
+		Name:      sc.Name,
+		Symbol:    sc.Symbol,
+		Portfolio: allocs,
+		Currency:  sc.Currency,
+		Execution: sc.Execution,
+		Model:     model.String(),
+		Paths:     mc.Paths,
+		Seed:      mc.Seed,
+		From:      sDate.Format("2006-01-02"),
+		To:        eDate.Format("2006-01-02"),
+		Baseline:  baseline,
+		Fans:      make(map[string]Fan),
+	}
+
+	start := time.Date(eDate.Year(), eDate.Month()+1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.AddDate(mc.Years, 0, 0)
+	// Paths are simulated concurrently, the strategies of every path one
+	// after another. Synthetic prices change on weekdays, so they are the
+	// trading days of the paths. All paths share the dates of the first one.
+	results := make([]map[string]outcome, mc.Paths)
+	err = runPool(ctx, Workers, mc.Paths, func(ctx context.Context, path int) error {
+		pathP := synth.NewProvider(h, gen, start, end, mc.Seed+int64(path))
+		scRes, err := runScenarioRange(ctx, pathP, sc, allocs, sim.WeekdayCalendar{}, start, end, 1)
+		if err != nil && err != ctx.Err() {
+			return fmt.Errorf("Path %d: %v", path, err)
+		}
+		if path == 0 {
+			for _, stratRes := range scRes.Results {
+				res.Dates = stratRes.Dates
+				break
+			}
+		}
+		results[path] = outcomes(scRes, false, true)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	irrs := make(map[string][]float64)
+	finalValues := make(map[string][]float64)
+	values := make(map[string][][]float64)
+	for _, pathRes := range results {
+		for name, out := range pathRes {
+			irrs[name] = append(irrs[name], out.irr)
+			finalValues[name] = append(finalValues[name], out.finalValue)
+			values[name] = append(values[name], out.values)
+		}
+	}
+
+	for name, paths := range values {
+		res.Fans[name] = fan(paths)
+	}
+	res.Stats = outcomeStats(irrs, finalValues, baseline)
+	return
+}
+
+// loadHistory loads the prices of `symbols` from `sDate` to `eDate` to fit
+// models of synthetic returns to.
+func loadHistory(priceP PriceProvider, symbols []string, sDate time.Time, eDate time.Time) (*synth.History, error) {
+	seriesP, ok := priceP.(SeriesProvider)
+	if !ok {
+		return nil, errors.New("The data source provides no price series")
+	}
+
+	from, to := sDate.Format("2006-01-02"), eDate.Format("2006-01-02")
+	series := make(map[string]map[string]float64)
+	for _, symbol := range symbols {
+		values, err := seriesP.GetSeries(symbol)
+		if err != nil {
+			return nil, err
+		}
+
+		series[symbol] = make(map[string]float64)
+		for date, value := range values {
+			if date >= from && date <= to {
+				series[symbol][date] = value
+			}
+		}
+	}
+	return synth.NewHistory(series)
+}
+
+// fan calculates the percentiles of the values of all `paths` on every
+// evaluation date.
+func fan(paths [][]float64) (f Fan) {
+	for i := range paths[0] {
+		column := make([]float64, len(paths))
+		for p, values := range paths {
+			column[p] = values[i]
+		}
+		sort.Float64s(column)
+
+		f.P5 = append(f.P5, sim.RoundTo(0, percentile(column, 0.05)))
+		f.P25 = append(f.P25, sim.RoundTo(0, percentile(column, 0.25)))
+		f.Median = append(f.Median, sim.RoundTo(0, percentile(column, 0.5)))
+		f.P75 = append(f.P75, sim.RoundTo(0, percentile(column, 0.75)))
+		f.P95 = append(f.P95, sim.RoundTo(0, percentile(column, 0.95)))
+	}
+	return
+}
+
+// title describes the portfolio and the paths of the result for charts.
+func (res MonteCarloResult) title() string {
+	title := sim.FormatAllocations(res.Portfolio)
+	if res.Currency != "" {
+		title += " in " + res.Currency
+	}
+	title += fmt.Sprintf(", %d %s paths fitted to %s to %s", res.Paths, res.Model, res.From, res.To)
+	return title + executionTitle(res.Execution)
+}
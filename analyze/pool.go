@@ -0,0 +1,58 @@
+package analyze
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Workers is the maximum number of simulations run concurrently by a
+// request.
+var Workers = runtime.NumCPU()
+
+// runPool calls `job` for every index from 0 to `jobs` - 1 on up to `workers`
+// goroutines. The first error cancels the context passed to the remaining
+// jobs and is returned once all started jobs have finished. Without an error,
+// the error of `ctx` is returned if it was done before all jobs ran.
+func runPool(ctx context.Context, workers int, jobs int, job func(context.Context, int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for w := 0; w < workers && w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := job(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < jobs; i++ {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,67 @@
+package analyze
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPoolResults(t *testing.T) {
+	results := make([]int, 100)
+	err := runPool(context.Background(), 4, len(results), func(ctx context.Context, i int) error {
+		results[i] = i * i
+		return nil
+	})
+	assert.Nil(t, err)
+	for i, res := range results {
+		assert.Equal(t, i*i, res, "Result of job %d at the wrong index", i)
+	}
+
+	// More workers than jobs
+	err = runPool(context.Background(), 8, 2, func(ctx context.Context, i int) error {
+		results[i] = -1
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{-1, -1, 4}, results[:3])
+}
+
+func TestRunPoolError(t *testing.T) {
+	errFailed := errors.New("failed")
+	var started int32
+	err := runPool(context.Background(), 2, 100, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&started, 1)
+		if i == 0 {
+			return errFailed
+		}
+		// All other jobs wait for the first error to cancel them
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, errFailed, err, "The first error should be returned")
+	assert.Less(t, int(atomic.LoadInt32(&started)), 100, "The error should cancel the remaining jobs")
+}
+
+func TestRunPoolCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started int32
+	err := runPool(ctx, 2, 100, func(ctx context.Context, i int) error {
+		if atomic.AddInt32(&started, 1) == 5 {
+			cancel()
+		}
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err, "The error of the parent context should be returned")
+	assert.Less(t, int(atomic.LoadInt32(&started)), 100, "Canceling should stop feeding jobs")
+
+	started = 0
+	err = runPool(ctx, 2, 100, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&started, 1)
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, int(atomic.LoadInt32(&started)), 100)
+}
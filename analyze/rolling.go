@@ -0,0 +1,270 @@
+package analyze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sgasse/finca/sim"
+)
+
+// DefaultRollingYears is the length of the windows of rolling backtests.
+var DefaultRollingYears = 10
+
+// A Distribution summarizes the outcomes of a strategy over all windows of a
+// rolling backtest or all paths of a Monte Carlo simulation.
+type Distribution struct {
+	Min    float64 `json:"min"`
+	P10    float64 `json:"p10"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// OutcomeStats holds the outcomes of a strategy over all windows or paths.
+type OutcomeStats struct {
+	// Internal rate of return in percent
+	IRR        Distribution `json:"irr"`
+	FinalValue Distribution `json:"finalValue"`
+	// Share of windows or paths in percent in which the strategy had a
+	// higher IRR than the baseline
+	WinRate float64 `json:"winRate"`
+}
+
+// RollingResult holds the outcome of simulating all strategies of a scenario
+// in every window of a given length, keyed by the names of the strategies.
+type RollingResult struct {
+	Name      string           `json:"name,omitempty"`
+	Symbol    string           `json:"symbol"`
+	Portfolio []sim.Allocation `json:"portfolio"`
+	// Length of the windows in years
+	Years int `json:"years"`
+	// Strategy the win rates are relative to
+	Baseline string `json:"baseline"`
+	// Values are given in money of the start of every window
+	Real      bool               `json:"real,omitempty"`
+	Currency  string             `json:"currency,omitempty"`
+	Execution *sim.ExecutionSpec `json:"execution,omitempty"`
+	// Start dates of all windows as `2006-01-02`
+	Starts []string `json:"starts"`
+	// IRR of every strategy in every window
+	IRRs  map[string][]float64    `json:"irrs"`
+	Stats map[string]OutcomeStats `json:"stats"`
+}
+
+// RunRolling simulates all strategies of `sc` in every window of `years`
+// years starting on the first day of a month within the date range of the
+// scenario. The IRR and the final value of every strategy are summarized
+// over all windows. The win rate of a strategy is the share of windows in
+// which it beat the strategy named `baseline`, which defaults to the first
+// strategy of the type `MidMonth` or the first strategy otherwise. Windows
+// are simulated concurrently by up to `Workers` goroutines until `ctx` is
+// done.
+func RunRolling(ctx context.Context, priceP PriceProvider, sc sim.Scenario, years int, baseline string) (res RollingResult, err error) {
+	if years <= 0 {
+		return res, fmt.Errorf("Window of %d years must be positive", years)
+	}
+	if err = sc.Validate(); err != nil {
+		return
+	}
+
+	if baseline, err = baselineName(sc.Strategies, baseline); err != nil {
+		return
+	}
+
+	var allocs []sim.Allocation
+	sc.Symbol, allocs = scenarioPortfolio(sc)
+	sDate, eDate, err := scenarioDateRange(priceP, sc, allocs)
+	if err != nil {
+		return
+	}
+
+	res = RollingResult{
+		Name:      sc.Name,
+		Symbol:    sc.Symbol,
+		Portfolio: allocs,
+		Years:     years,
+		Baseline:  baseline,
+		Real:      sc.Real,
+		Currency:  sc.Currency,
+		Execution: sc.Execution,
+		IRRs:      make(map[string][]float64),
+	}
+
+	start := sDate
+	if start.Day() != 1 {
+		start = time.Date(start.Year(), start.Month()+1, 1, 12, 0, 0, 0, time.UTC)
+	}
+	var windows []sim.Scenario
+	for ; !start.AddDate(years, 0, 0).After(eDate); start = start.AddDate(0, 1, 0) {
+		window := sc
+		window.From = start.Format("2006-01-02")
+		window.To = start.AddDate(years, 0, 0).Format("2006-01-02")
+		windows = append(windows, window)
+	}
+
+	if len(windows) == 0 {
+		return res, errors.New(fmt.Sprint("No window of ", years, " years between ",
+			sDate.Format("2006-01-02"), " and ", eDate.Format("2006-01-02")))
+	}
+
+	cal, err := scenarioCalendar(priceP, sc, allocs)
+	if err != nil {
+		return
+	}
+
+	// Windows are simulated concurrently, the strategies of every window
+	// one after another
+	results := make([]map[string]outcome, len(windows))
+	err = runPool(ctx, Workers, len(windows), func(ctx context.Context, i int) error {
+		window := windows[i]
+		sDate, eDate, err := scenarioDateRange(priceP, window, allocs)
+		if err != nil {
+			return fmt.Errorf("Window from %s: %v", window.From, err)
+		}
+		scRes, err := runScenarioRange(ctx, priceP, window, allocs, cal, sDate, eDate, 1)
+		if err != nil && err != ctx.Err() {
+			return fmt.Errorf("Window from %s: %v", window.From, err)
+		}
+		results[i] = outcomes(scRes, sc.Real, false)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	finalValues := make(map[string][]float64)
+	for i, windowRes := range results {
+		res.Starts = append(res.Starts, windows[i].From)
+		for name, out := range windowRes {
+			res.IRRs[name] = append(res.IRRs[name], out.irr)
+			finalValues[name] = append(finalValues[name], out.finalValue)
+		}
+	}
+
+	res.Stats = outcomeStats(res.IRRs, finalValues, baseline)
+	return
+}
+
+// An outcome is the part of the result of a strategy in a window or on a path
+// which is summarized, so that the ledgers of all windows and paths are not
+// kept in memory.
+type outcome struct {
+	irr        float64
+	finalValue float64
+	// Values on the evaluation dates, only kept for the fans of Monte Carlo
+	// simulations
+	values []float64
+}
+
+// outcomes reduces the results of all strategies of `scRes` to their
+// outcomes, in real values if `real` is set. The values on the evaluation
+// dates are only kept if `keepValues` is set.
+func outcomes(scRes ScenarioResult, real bool, keepValues bool) map[string]outcome {
+	outs := make(map[string]outcome, len(scRes.Results))
+	for name, stratRes := range scRes.Results {
+		out := outcome{irr: stratRes.IRR, finalValue: stratRes.FinalValue}
+		if real {
+			out.irr, out.finalValue = stratRes.RealIRR, stratRes.RealFinalValue
+		}
+		if keepValues {
+			out.values = stratRes.Values
+		}
+		outs[name] = out
+	}
+	return outs
+}
+
+// outcomeStats summarizes the IRRs and final values of every strategy in all
+// windows or paths. Win rates are relative to the strategy `baseline`.
+func outcomeStats(irrs map[string][]float64, finalValues map[string][]float64, baseline string) map[string]OutcomeStats {
+	stats := make(map[string]OutcomeStats)
+	for name, stratIRRs := range irrs {
+		wins := 0
+		for i, irr := range stratIRRs {
+			if irr > irrs[baseline][i] {
+				wins++
+			}
+		}
+		stats[name] = OutcomeStats{
+			IRR:        distribution(stratIRRs),
+			FinalValue: distribution(finalValues[name]),
+			WinRate:    sim.RoundTo(2, float64(wins)/float64(len(stratIRRs))*100),
+		}
+	}
+	return stats
+}
+
+// baselineName returns `name` if it is the name of one of `specs`. An empty
+// name defaults to the first strategy of the type `MidMonth` or the first
+// strategy otherwise.
+func baselineName(specs []sim.StrategySpec, name string) (string, error) {
+	if name == "" {
+		for _, spec := range specs {
+			if spec.Type == "MidMonth" {
+				return spec.DisplayName(), nil
+			}
+		}
+		return specs[0].DisplayName(), nil
+	}
+
+	for _, spec := range specs {
+		if spec.DisplayName() == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("Baseline %q is no strategy of the scenario", name)
+}
+
+// distribution summarizes `values` by their percentiles and mean.
+func distribution(values []float64) Distribution {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, value := range sorted {
+		sum += value
+	}
+
+	return Distribution{
+		Min:    sim.RoundTo(2, sorted[0]),
+		P10:    sim.RoundTo(2, percentile(sorted, 0.1)),
+		P25:    sim.RoundTo(2, percentile(sorted, 0.25)),
+		Median: sim.RoundTo(2, percentile(sorted, 0.5)),
+		P75:    sim.RoundTo(2, percentile(sorted, 0.75)),
+		P90:    sim.RoundTo(2, percentile(sorted, 0.9)),
+		Max:    sim.RoundTo(2, sorted[len(sorted)-1]),
+		Mean:   sim.RoundTo(2, sum/float64(len(sorted))),
+	}
+}
+
+// percentile returns the percentile `p` between 0 and 1 of the ascending
+// values `sorted`, interpolating linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// title describes the portfolio and the windows of the result for charts.
+func (res RollingResult) title() string {
+	title := sim.FormatAllocations(res.Portfolio)
+	if res.Currency != "" {
+		title += " in " + res.Currency
+	}
+	title += fmt.Sprintf(", %d windows of %d years starting %s to %s",
+		len(res.Starts), res.Years, res.Starts[0], res.Starts[len(res.Starts)-1])
+	if res.Real {
+		title += " in money of their start"
+	}
+	return title + executionTitle(res.Execution)
+}
@@ -3,24 +3,43 @@ package analyze
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
-	"github.com/sgasse/finca/av"
 	"github.com/sgasse/finca/sim"
 )
 
 var (
-	startDate = time.Date(2000, 1, 1, 10, 0, 0, 0, time.UTC)
-	symbol    = "SPY"
+	priceP PriceProvider
+	// ScenarioDir is the directory scenario files are loaded from. It can be
+	// set with the environment variable `FINCA_SCENARIO_DIR`.
+	ScenarioDir = "scenarios"
+	// TransactionDir is the directory the trades of real portfolios are
+	// loaded from. It can be set with the environment variable
+	// `FINCA_TRANSACTIONS_DIR`.
+	TransactionDir = "transactions"
+	// Now is the clock used to limit the end of simulations when no `to`
+	// parameter is given. Replace it to reproduce results of a given day.
+	Now = time.Now
 )
 
 // LaunchVisualizer creates a server mux to visualize simulation callbacks
-// with charts in the browser. A custom port can be set with the
-// environment variable `ANALYZER_PORT`.
-func LaunchVisualizer() {
+// with charts in the browser. All prices are looked up with `inPriceP`. A
+// custom port can be set with the environment variable `ANALYZER_PORT`.
+func LaunchVisualizer(inPriceP PriceProvider) {
+	priceP = inPriceP
+
+	if dir := os.Getenv("FINCA_SCENARIO_DIR"); dir != "" {
+		ScenarioDir = dir
+	}
+	if dir := os.Getenv("FINCA_TRANSACTIONS_DIR"); dir != "" {
+		TransactionDir = dir
+	}
+
 	port := os.Getenv("ANALYZER_PORT")
 	if port == "" {
 		port = "3310"
@@ -33,219 +52,412 @@ func LaunchVisualizer() {
 	mux.Handle("/biyearly", chartHandler(biyearly))
 	mux.Handle("/drawdown", chartHandler(drawdown))
 	mux.Handle("/adaptiveperiodic", chartHandler(adaptivePeriodic))
+	mux.Handle("/scenario", chartHandler(scenario))
+	mux.Handle("/rolling", chartHandler(rolling))
+	mux.Handle("/montecarlo", chartHandler(monteCarlo))
+	mux.Handle("/ledger", chartHandler(ledger))
+	mux.Handle("/api/simulate", apiHandler(simulateAPI))
+	mux.Handle("/api/ledger", apiHandler(ledgerAPI))
 	http.ListenAndServe(":"+port, mux)
 }
 
 // A chartHandler wraps a HTTP handler that might return an error. If the
 // wrapped handler does return an error, this error is written to the HTTP
-// response with the error code 500.
+// response with the error code 500, or 400 for a `badRequest`.
 type chartHandler func(http.ResponseWriter, *http.Request) error
 
 // ServeHTTP tries to serve a HTTP request with the wrapped handler. If
 // this handler errors, the error is returned as response with error code
-// 500. This makes the chartHandler interface implement `http.Handler`.
+// 500, or 400 for a `badRequest`. This makes the chartHandler interface
+// implement `http.Handler`.
 func (fn chartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := fn(w, r); err != nil {
-		http.Error(w, err.Error(), 500)
+		status := 500
+		if _, ok := err.(badRequest); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 	}
 }
 
+// A badRequest is an error of the parameters of a request, answered with the
+// status 400 by `chartHandler`.
+type badRequest struct {
+	error
+}
+
+// CompareSpecs are the strategies compared on `/compare`.
+var CompareSpecs = []sim.StrategySpec{
+	{Name: "Monthly", Type: "MidMonth"},
+	{Name: "NoInvest", Type: "NoInvest"},
+	{Name: "January/July", Type: "FixedMonths", Months: []int{1, 6}},
+	{Name: "April/October", Type: "FixedMonths", Months: []int{4, 10}},
+	{Name: "30%Drawdown", Type: "MinDrawdown", Drawdown: 0.3},
+	{Name: "55%Drawdown", Type: "MinDrawdown", Drawdown: 0.55},
+	{Name: "6m||30%Drawdown", Type: "AdaptivePeriodic", WaitDays: 182, Drawdown: 0.3},
+	{Name: "6m||55%Drawdown", Type: "AdaptivePeriodic", WaitDays: 182, Drawdown: 0.55},
+}
+
 func compareStrats(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		err := maybeSetParams(r)
+		res, simRes, err := runParamScenario(r, CompareSpecs)
 		if err != nil {
 			return err
 		}
 
-		simRes := newSimRes()
-		waitTime := time.Duration(182*24) * time.Hour
+		dates, stockTs, stockRelChange, stockDrawdown := evalSingleStockData(priceP, res.start, res.end, res.Symbol)
+
+		return renderCharts(w,
+			[]chartRes{
+				wrapCR(multiSeriesChart(res.title(), "hybrid_strats", simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
+				wrapCR(multiSeriesChart(res.title(), "hybrid_strats", simRes.Dates, simRes.IRR, "templates/barComp.html")),
+				wrapCR(multiSeriesChart(res.title(), "hybrid_strats", simRes.Dates, simRes.Metrics, "templates/metricsTable.html")),
+				wrapCR(xyTemplate(res.Symbol, dates, stockTs, "templates/stockprice.html")),
+				wrapCR(xyTemplate(res.Symbol, dates, stockDrawdown, "templates/drawdown.html")),
+				wrapCR(xyTemplate(res.Symbol, dates, stockRelChange, "templates/relChange.html")),
+			},
+		)
+	}
+	return nil
+}
 
-		strats := map[string]sim.Strategy{
-			"Monthly":         sim.NewMonthlyStrategy(startDate),
-			"NoInvest":        &sim.NoInvest{},
-			"January/July":    sim.NewFixedMonthsStrategy(startDate, []time.Month{1, 6}),
-			"April/October":   sim.NewFixedMonthsStrategy(startDate, []time.Month{4, 10}),
-			"30%Drawdown":     sim.NewMinDrawdown(0.7, symbol, &av.AvProvider{}),
-			"55%Drawdown":     sim.NewMinDrawdown(0.45, symbol, &av.AvProvider{}),
-			"6m||30%Drawdown": sim.NewAdaptivePeriodic(startDate, waitTime, 0.7, symbol, &av.AvProvider{}),
-			"6m||55%Drawdown": sim.NewAdaptivePeriodic(startDate, waitTime, 0.45, symbol, &av.AvProvider{}),
+func showStock(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		sc, err := scenarioFromParams(r)
+		if err != nil {
+			return err
+		}
+		if sc.Symbol == "" {
+			sc.Symbol = DefaultSymbol
 		}
 
-		if err = addSimResults(&simRes, strats); err != nil {
+		sDate, eDate, err := simDateRange(priceP, sc.Symbol, sc.From, sc.To)
+		if err != nil {
 			return err
 		}
 
-		dates, stockTs, stockRelChange, stockDrawdown := evalSingleStockData(startDate, symbol)
+		dates, stockTs, stockRelChange, stockDrawdown := evalSingleStockData(priceP, sDate, eDate, sc.Symbol)
 
-		chData, err := combineCharts(
+		return renderCharts(w,
 			[]chartRes{
-				wrapCR(multiSeriesChart(symbol, "hybrid_strats", simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
-				wrapCR(multiSeriesChart(symbol, "hybrid_strats", simRes.Dates, simRes.IRR, "templates/barComp.html")),
-				wrapCR(xyTemplate(symbol, dates, stockTs, "templates/stockprice.html")),
-				wrapCR(xyTemplate(symbol, dates, stockDrawdown, "templates/drawdown.html")),
-				wrapCR(xyTemplate(symbol, dates, stockRelChange, "templates/relChange.html")),
+				wrapCR(xyTemplate(sc.Symbol, dates, stockTs, "templates/stockprice.html")),
+				wrapCR(xyTemplate(sc.Symbol, dates, stockDrawdown, "templates/drawdown.html")),
+				wrapCR(xyTemplate(sc.Symbol, dates, stockRelChange, "templates/relChange.html")),
 			},
 		)
+	}
+	return nil
+}
+
+func biyearly(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		specs := []sim.StrategySpec{{Name: "NoInvest", Type: "NoInvest"}}
+		for i := 1; i <= 6; i++ {
+			specs = append(specs, sim.StrategySpec{
+				Name:   fmt.Sprint(time.Month(i), "/", time.Month(i+6)),
+				Type:   "FixedMonths",
+				Months: []int{i, i + 6},
+			})
+		}
 
-		t, err := template.ParseFiles("templates/compare.html")
+		res, simRes, err := runParamScenario(r, specs)
 		if err != nil {
 			return err
 		}
 
-		t.Execute(w, &chData)
+		return renderComparison(w, res.title(), "biyearly_strats", simRes)
 	}
 	return nil
 }
 
-func showStock(w http.ResponseWriter, r *http.Request) error {
+func drawdown(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		err := maybeSetParams(r)
+		specs := []sim.StrategySpec{{Name: "NoInvest", Type: "NoInvest"}}
+		for perc := 5; perc <= 70; perc += 5 {
+			specs = append(specs, sim.StrategySpec{
+				Name:     fmt.Sprintf("%d%%Drawdown", perc),
+				Type:     "MinDrawdown",
+				Drawdown: float64(perc) / 100,
+			})
+		}
+
+		res, simRes, err := runParamScenario(r, specs)
 		if err != nil {
 			return err
 		}
 
-		dates, stockTs, stockRelChange, stockDrawdown := evalSingleStockData(startDate, symbol)
+		return renderComparison(w, res.title(), "drawdown_strats", simRes)
+	}
+	return nil
+}
 
-		chData, err := combineCharts(
-			[]chartRes{
-				wrapCR(xyTemplate(symbol, dates, stockTs, "templates/stockprice.html")),
-				wrapCR(xyTemplate(symbol, dates, stockDrawdown, "templates/drawdown.html")),
-				wrapCR(xyTemplate(symbol, dates, stockRelChange, "templates/relChange.html")),
-			},
-		)
+func adaptivePeriodic(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		specs := []sim.StrategySpec{{Name: "NoInvest", Type: "NoInvest"}}
+		for perc := 5; perc <= 70; perc += 5 {
+			specs = append(specs, sim.StrategySpec{
+				Name:     fmt.Sprintf("6m||%d%%Drawdown", perc),
+				Type:     "AdaptivePeriodic",
+				WaitDays: 182,
+				Drawdown: float64(perc) / 100,
+			})
+		}
 
-		t, err := template.ParseFiles("templates/compare.html")
+		res, simRes, err := runParamScenario(r, specs)
 		if err != nil {
 			return err
 		}
 
-		t.Execute(w, &chData)
+		return renderComparison(w, res.title(), "adaptive_periodic_strats", simRes)
 	}
 	return nil
 }
 
-func biyearly(w http.ResponseWriter, r *http.Request) error {
+// scenario shows the comparison of a scenario file given by the query
+// parameter `name`. The symbols, dates, fees, rebalancing, fractional shares
+// and income of the scenario can be overridden with query parameters.
+func scenario(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		err := maybeSetParams(r)
+		sc, err := loadNamedScenario(r.URL.Query().Get("name"))
 		if err != nil {
 			return err
 		}
 
-		simRes := newSimRes()
-
-		if err = addSimResult(&simRes, &sim.NoInvest{}, "NoInvest"); err != nil {
+		params, err := scenarioFromParams(r)
+		if err != nil {
 			return err
 		}
+		overrideScenario(&sc, params, r.URL.Query())
 
-		for i := 1; i <= 6; i++ {
-			strat := sim.NewFixedMonthsStrategy(startDate, []time.Month{time.Month(i),
-				time.Month(i + 6)})
-			name := fmt.Sprint(time.Month(i), "/", time.Month(i+6))
-
-			if err = addSimResult(&simRes, strat, name); err != nil {
-				return err
-			}
+		res, err := RunScenario(r.Context(), priceP, sc)
+		if err != nil {
+			return err
 		}
-
-		chData, err := combineCharts(
-			[]chartRes{
-				wrapCR(multiSeriesChart(symbol, "biyearly_strats", simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
-				wrapCR(multiSeriesChart(symbol, "biyearly_strats", simRes.Dates, simRes.IRR, "templates/barComp.html")),
-			},
-		)
-
-		t, err := template.ParseFiles("templates/compare.html")
+		simRes, err := res.simResults()
 		if err != nil {
 			return err
 		}
 
-		t.Execute(w, &chData)
+		return renderComparison(w, res.title(), "scenario_"+sc.Name, simRes)
 	}
 	return nil
 }
 
-func drawdown(w http.ResponseWriter, r *http.Request) error {
+// rolling shows the distribution of outcomes of the strategies of `/compare`
+// or of the scenario file given by the query parameter `name` over rolling
+// windows of `years` years. Win rates are relative to the strategy named
+// `baseline`. The scenario can be overridden with the query parameters of
+// `/scenario`.
+func rolling(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		err := maybeSetParams(r)
+		sc, err := compareOrNamedScenario(r)
 		if err != nil {
 			return err
 		}
 
-		simRes := newSimRes()
-
-		if err = addSimResult(&simRes, &sim.NoInvest{}, "NoInvest"); err != nil {
-			return err
-		}
-
-		for relVal := 0.95; relVal >= 0.3; relVal -= 0.05 {
-			perc := (1.0 - relVal) * 100
-			err = addSimResult(
-				&simRes,
-				sim.NewMinDrawdown(relVal, symbol, &av.AvProvider{}),
-				fmt.Sprintf("%.0f", perc)+"%Drawdown",
-			)
-			if err != nil {
-				log.Fatal(err)
+		query := r.URL.Query()
+		years := DefaultRollingYears
+		if param := query.Get("years"); param != "" {
+			if years, err = strconv.Atoi(param); err != nil {
+				return err
 			}
 		}
 
-		chData, err := combineCharts(
-			[]chartRes{
-				wrapCR(multiSeriesChart(symbol, "drawdown_strats", simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
-				wrapCR(multiSeriesChart(symbol, "drawdown_strats", simRes.Dates, simRes.IRR, "templates/barComp.html")),
-			},
-		)
-
-		t, err := template.ParseFiles("templates/compare.html")
+		res, err := RunRolling(r.Context(), priceP, sc, years, query.Get("baseline"))
 		if err != nil {
 			return err
 		}
 
-		t.Execute(w, &chData)
+		name := "rolling_" + sc.Name
+		return renderCharts(w,
+			[]chartRes{
+				wrapCR(outcomeChart(res.title(), name, res, "templates/outcomesBox.html")),
+				wrapCR(outcomeChart(res.title(), name, res, "templates/rollingIRR.html")),
+				wrapCR(outcomeChart(res.title(), name, res, "templates/outcomesTable.html")),
+			},
+		)
 	}
 	return nil
 }
 
-func adaptivePeriodic(w http.ResponseWriter, r *http.Request) error {
+// monteCarlo shows fan charts of the portfolio values and the distribution
+// of outcomes of the strategies of `/compare` or of the scenario file given
+// by the query parameter `name` on synthetic price paths. The paths are
+// read by `monteCarloFromParams`, win rates are relative to the strategy
+// named `baseline`. The scenario can be overridden with the query
+// parameters of `/scenario`.
+func monteCarlo(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		err := maybeSetParams(r)
+		sc, err := compareOrNamedScenario(r)
 		if err != nil {
 			return err
 		}
 
-		simRes := newSimRes()
-
-		if err = addSimResult(&simRes, &sim.NoInvest{}, "NoInvest"); err != nil {
-			return err
+		query := r.URL.Query()
+		mc, err := monteCarloFromParams(query)
+		if err != nil {
+			return badRequest{err}
 		}
 
-		waitTime := time.Duration(182*24) * time.Hour
-
-		for relVal := 0.95; relVal >= 0.3; relVal -= 0.05 {
-			perc := (1.0 - relVal) * 100
-			err = addSimResult(
-				&simRes,
-				sim.NewAdaptivePeriodic(startDate, waitTime, relVal, symbol, &av.AvProvider{}),
-				fmt.Sprintf("6m||%.0f", perc)+"%Drawdown",
-			)
-			if err != nil {
-				log.Fatal(err)
-			}
+		res, err := RunMonteCarlo(r.Context(), priceP, sc, mc, query.Get("baseline"))
+		if err != nil {
+			return err
 		}
 
-		chData, err := combineCharts(
+		name := "montecarlo_" + sc.Name
+		return renderCharts(w,
 			[]chartRes{
-				wrapCR(multiSeriesChart(symbol, "adaptive_periodic_strats", simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
-				wrapCR(multiSeriesChart(symbol, "adaptive_periodic_strats", simRes.Dates, simRes.IRR, "templates/barComp.html")),
+				wrapCR(outcomeChart(res.title(), name, res, "templates/fanCharts.html")),
+				wrapCR(outcomeChart(res.title(), name, res, "templates/outcomesBox.html")),
+				wrapCR(outcomeChart(res.title(), name, res, "templates/outcomesTable.html")),
 			},
 		)
+	}
+	return nil
+}
 
-		t, err := template.ParseFiles("templates/compare.html")
-		if err != nil {
-			return err
+// compareOrNamedScenario returns the scenario file given by the query
+// parameter `name` or the strategies of `/compare`, overridden with the
+// query parameters of `r`.
+func compareOrNamedScenario(r *http.Request) (sim.Scenario, error) {
+	sc := sim.Scenario{Strategies: CompareSpecs}
+	if name := r.URL.Query().Get("name"); name != "" {
+		var err error
+		if sc, err = loadNamedScenario(name); err != nil {
+			return sc, err
 		}
+	}
 
-		t.Execute(w, &chData)
+	params, err := scenarioFromParams(r)
+	if err != nil {
+		return sc, err
 	}
-	return nil
+	overrideScenario(&sc, params, r.URL.Query())
+	return sc, nil
+}
+
+// overrideScenario overrides the fields of `sc` with the fields given in
+// `params`, the scenario read by `scenarioFromParams` from `query`. Fields
+// whose zero value is a valid setting are overridden if their parameter is
+// present in `query`.
+func overrideScenario(sc *sim.Scenario, params sim.Scenario, query url.Values) {
+	// A single symbol replaces the portfolio of the scenario
+	if params.Portfolio != nil {
+		sc.Portfolio = params.Portfolio
+		sc.Symbol = params.Symbol
+	} else if params.Symbol != "" {
+		sc.Symbol = params.Symbol
+		sc.Portfolio = nil
+	}
+	if params.From != "" {
+		sc.From = params.From
+	}
+	if params.To != "" {
+		sc.To = params.To
+	}
+	if params.Fees != nil {
+		sc.Fees = params.Fees
+	}
+	if params.Income != nil {
+		sc.Income = params.Income
+	}
+	if params.Rebalance != nil {
+		sc.Rebalance = params.Rebalance
+	}
+	if _, ok := query["fractional"]; ok {
+		sc.Fractional = params.Fractional
+	}
+	if params.Dividends != "" {
+		sc.Dividends = params.Dividends
+	}
+	if params.Tax != nil {
+		sc.Tax = params.Tax
+	}
+	if _, ok := query["real"]; ok {
+		sc.Real = params.Real
+	}
+	if params.Currency != "" {
+		sc.Currency = params.Currency
+	}
+	if params.Execution != nil {
+		sc.Execution = params.Execution
+	}
+	if params.Transactions != "" {
+		sc.Transactions = params.Transactions
+	}
+}
+
+// loadNamedScenario loads the scenario file `<name>.yaml`, `<name>.yml` or
+// `<name>.json` from the directory given by `ScenarioDir`.
+func loadNamedScenario(name string) (sim.Scenario, error) {
+	if name == "" || filepath.Base(name) != name {
+		return sim.Scenario{}, fmt.Errorf("Invalid scenario name %q", name)
+	}
+
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		path := filepath.Join(ScenarioDir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return sim.LoadScenario(path)
+		}
+	}
+	return sim.Scenario{}, fmt.Errorf("Scenario %q not found in %s", name, ScenarioDir)
+}
+
+// transactionsPath returns the path of the file `<name>.csv` with the trades
+// of a real portfolio in the directory given by `TransactionDir`.
+func transactionsPath(name string) (string, error) {
+	if filepath.Base(name) != name {
+		return "", fmt.Errorf("Invalid transactions name %q", name)
+	}
+
+	path := filepath.Join(TransactionDir, name+".csv")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("Transactions %q not found in %s", name, TransactionDir)
+	}
+	return path, nil
+}
+
+// runParamScenario simulates `specs` in the scenario given by the query
+// parameters of `r`.
+func runParamScenario(r *http.Request, specs []sim.StrategySpec) (res ScenarioResult, simRes SimResults, err error) {
+	sc, err := scenarioFromParams(r)
+	if err != nil {
+		return
+	}
+	sc.Strategies = specs
+
+	res, err = RunScenario(r.Context(), priceP, sc)
+	if err != nil {
+		return
+	}
+
+	simRes, err = res.simResults()
+	return
+}
+
+// renderComparison renders the portfolio values, IRRs and metrics of
+// `simRes`.
+func renderComparison(w http.ResponseWriter, symbol string, name string, simRes SimResults) error {
+	return renderCharts(w,
+		[]chartRes{
+			wrapCR(multiSeriesChart(symbol, name, simRes.Dates, simRes.TimeSeries, "templates/timeSeriesComp.html")),
+			wrapCR(multiSeriesChart(symbol, name, simRes.Dates, simRes.IRR, "templates/barComp.html")),
+			wrapCR(multiSeriesChart(symbol, name, simRes.Dates, simRes.Metrics, "templates/metricsTable.html")),
+		},
+	)
+}
+
+// renderCharts writes the page with all charts of `chRes` to `w`.
+func renderCharts(w http.ResponseWriter, chRes []chartRes) error {
+	chData, err := combineCharts(chRes)
+	if err != nil {
+		return err
+	}
+
+	t, err := template.ParseFiles("templates/compare.html")
+	if err != nil {
+		return err
+	}
+
+	return t.Execute(w, &chData)
 }
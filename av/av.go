@@ -10,10 +10,17 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/sgasse/finca/ohlc"
 )
 
+// CPISymbol is the symbol of the monthly consumer price index of the US. The
+// `$` cannot be part of a ticker, so it does not collide with prices.
+const CPISymbol = "$CPI"
+
 var (
 	avAPIKey    string
 	SigChan     = make(chan os.Signal, 1)
@@ -23,8 +30,14 @@ var (
 		sync.RWMutex
 		m map[string]tsDailyAdjResp
 	}{m: make(map[string]tsDailyAdjResp)}
+	// fetchLock serializes updates of the cache so that concurrent
+	// simulations missing the same symbol query it only once
+	fetchLock sync.Mutex
 	cacheFile = ".avCache.json"
 	cachePath string
+	// fxSuffix marks symbols of exchange rates like `USDEUR=X`, the price of
+	// one USD in EUR.
+	fxSuffix = "=X"
 )
 
 type tsDailyAdjMd struct {
@@ -46,18 +59,65 @@ type tsDailyAdj struct {
 	SplitCoefficient float64 `json:"8. split coefficient,string"`
 }
 
+type cpiPoint struct {
+	Date  string  `json:"date"`
+	Value float64 `json:"value,string"`
+}
+
+type cpiResp struct {
+	Name string     `json:"name"`
+	Data []cpiPoint `json:"data"`
+}
+
+type fxDaily struct {
+	Open  float64 `json:"1. open,string"`
+	High  float64 `json:"2. high,string"`
+	Low   float64 `json:"3. low,string"`
+	Close float64 `json:"4. close,string"`
+}
+
+type fxResp struct {
+	TimeSeries map[string]fxDaily `json:"Time Series FX (Daily)"`
+}
+
 type tsDailyAdjResp struct {
 	MetaData    tsDailyAdjMd          `json:"Meta Data"`
 	TimeSeries  map[string]tsDailyAdj `json:"Time Series (Daily)"`
 	LastQueried time.Time             `json:"lastQueried"`
 }
 
+// AvProvider provides prices and date ranges of symbols from the cached
+// AlphaVantage data.
 type AvProvider struct{}
 
 func (a *AvProvider) GetPrice(symbol string, date time.Time) (float64, error) {
 	return GetPrice(symbol, date)
 }
 
+func (a *AvProvider) GetDateRange(symbol string) (earliest, latest string, err error) {
+	return GetDateRange(symbol)
+}
+
+func (a *AvProvider) GetSeries(symbol string) (map[string]float64, error) {
+	return GetSeries(symbol)
+}
+
+func (a *AvProvider) GetRawPrice(symbol string, date time.Time) (float64, error) {
+	return GetRawPrice(symbol, date)
+}
+
+func (a *AvProvider) GetDividend(symbol string, date time.Time) (float64, error) {
+	return GetDividend(symbol, date)
+}
+
+func (a *AvProvider) GetSplit(symbol string, date time.Time) (float64, error) {
+	return GetSplit(symbol, date)
+}
+
+func (a *AvProvider) GetBar(symbol string, date time.Time) (ohlc.Bar, bool, error) {
+	return GetBar(symbol, date)
+}
+
 func LaunchAV(inAvAPIKey string) {
 	signal.Notify(SigChan, os.Interrupt)
 
@@ -77,28 +137,118 @@ func LaunchAV(inAvAPIKey string) {
 
 }
 
+// Fetch makes sure that the latest data of `symbol` is in the cache.
+func Fetch(symbol string) error {
+	return maybeUpdateCacheSymbol(symbol)
+}
+
+// SaveCache writes the cache to disk if its location was determined in
+// `LaunchAV`.
+func SaveCache() {
+	if cachePath == "" {
+		return
+	}
+	saveCache(cachePath)
+}
+
 func GetPrice(symbol string, date time.Time) (float64, error) {
-	// Ensure latest data is available
+	dailyData, found, err := getDailyData(symbol, date, 7)
+	if err != nil {
+		return 0.0, err
+	}
+	if !found {
+		return 0.0, errors.New(fmt.Sprint("Could not find a price for ", symbol))
+	}
+	return dailyData.AdjustedClose, nil
+}
+
+// GetSeries returns the adjusted close prices of `symbol` on all dates as
+// `2006-01-02`. With `CPISymbol`, the consumer price index is returned.
+func GetSeries(symbol string) (map[string]float64, error) {
 	err := maybeUpdateCacheSymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.RLock()
+	defer cache.RUnlock()
+	values := make(map[string]float64, len(cache.m[symbol].TimeSeries))
+	for date, dailyData := range cache.m[symbol].TimeSeries {
+		values[date] = dailyData.AdjustedClose
+	}
+	return values, nil
+}
+
+// GetRawPrice returns the close price of `symbol` on `date` which is not
+// adjusted for dividends and splits.
+func GetRawPrice(symbol string, date time.Time) (float64, error) {
+	dailyData, found, err := getDailyData(symbol, date, 7)
 	if err != nil {
 		return 0.0, err
 	}
+	if !found {
+		return 0.0, errors.New(fmt.Sprint("Could not find a price for ", symbol))
+	}
+	return dailyData.Close, nil
+}
+
+// GetDividend returns the dividend per share of `symbol` going ex on exactly
+// `date`, 0 if there is none.
+func GetDividend(symbol string, date time.Time) (float64, error) {
+	dailyData, _, err := getDailyData(symbol, date, 0)
+	return dailyData.DividendAmount, err
+}
+
+// GetSplit returns the split coefficient of `symbol` on exactly `date`, 1 if
+// there is no split.
+func GetSplit(symbol string, date time.Time) (float64, error) {
+	dailyData, found, err := getDailyData(symbol, date, 0)
+	if err != nil {
+		return 1.0, err
+	}
+	if !found || dailyData.SplitCoefficient == 0.0 {
+		return 1.0, nil
+	}
+	return dailyData.SplitCoefficient, nil
+}
+
+// GetBar returns the open, high, low and close prices of `symbol` on exactly
+// `date` and tells if the symbol traded on this date.
+func GetBar(symbol string, date time.Time) (ohlc.Bar, bool, error) {
+	dailyData, found, err := getDailyData(symbol, date, 0)
+	if err != nil || !found {
+		return ohlc.Bar{}, false, err
+	}
+	return ohlc.Bar{
+		Open:          dailyData.Open,
+		High:          dailyData.High,
+		Low:           dailyData.Low,
+		Close:         dailyData.Close,
+		AdjustedClose: dailyData.AdjustedClose,
+	}, true, nil
+}
+
+// getDailyData looks up the data of `symbol` on `date` or up to
+// `maxDaysBack` days previously.
+func getDailyData(symbol string, date time.Time, maxDaysBack int) (dailyData tsDailyAdj, found bool, err error) {
+	// Ensure latest data is available
+	err = maybeUpdateCacheSymbol(symbol)
+	if err != nil {
+		return
+	}
 
 	cache.RLock()
 	// Existance of symbol was ensured in `maybeUpdateCacheSymbol`
 	tsData, _ := cache.m[symbol]
 	cache.RUnlock()
 
-	// Check for price on the exact date or up to one week previously
-	for i := 0; i <= 7; i++ {
+	for i := 0; i <= maxDaysBack; i++ {
 		dateS := date.Add(-time.Duration(i) * 24 * time.Hour).Format("2006-01-02")
-		dailyData, ok := tsData.TimeSeries[dateS]
-		if ok {
-			return dailyData.AdjustedClose, nil
+		if dailyData, found = tsData.TimeSeries[dateS]; found {
+			return
 		}
 	}
-	//log.Print("No price found")
-	return 0.0, errors.New(fmt.Sprint("Could not find a price for ", symbol))
+	return
 }
 
 func GetDateRange(symbol string) (earliest, latest string, err error) {
@@ -128,59 +278,84 @@ func avURL(symbol string, APIKey string) string {
 
 }
 
-func maybeUpdateCacheSymbol(symbol string) error {
-	// Check if data for symbol exists
-	cache.RLock()
-	tsData, entryFound := cache.m[symbol]
-	cache.RUnlock()
+func cpiURL(APIKey string) string {
+	return fmt.Sprintf("https://www.alphavantage.co/query?function=CPI&interval=monthly&apikey=%s", APIKey)
+}
 
-	tooOld := false
-	if entryFound {
-		if tsData.LastQueried.Before(time.Now().Truncate(24 * time.Hour)) {
-			// Cache too old, re-query
-			tooOld = true
-		}
+func fxURL(from string, to string, APIKey string) string {
+	return fmt.Sprintf("https://www.alphavantage.co/query?function=FX_DAILY&from_symbol=%s&to_symbol=%s&outputsize=full&apikey=%s",
+		from, to, APIKey)
+}
+
+func maybeUpdateCacheSymbol(symbol string) error {
+	if isCached(symbol) {
+		return nil
 	}
 
-	if !entryFound || tooOld {
-		// Try to get the price for the symbol
-		client := http.Client{Timeout: time.Second * 5}
-		tsData, err := getTsDailyAdj(symbol, &client)
-		if err != nil {
-			return err
-		}
-		tsData.LastQueried = time.Now()
+	fetchLock.Lock()
+	defer fetchLock.Unlock()
+
+	// Another goroutine might have updated the symbol while waiting
+	if isCached(symbol) {
+		return nil
+	}
 
-		// Cache entry
-		cache.Lock()
-		cache.m[symbol] = tsData
-		cache.Unlock()
+	// Try to get the price for the symbol
+	client := http.Client{Timeout: time.Second * 5}
+	fetch := getTsDailyAdj
+	if symbol == CPISymbol {
+		fetch = getCPI
+	} else if strings.HasSuffix(symbol, fxSuffix) {
+		fetch = getFX
+	}
+	tsData, err := fetch(symbol, &client)
+	if err != nil {
+		return err
 	}
+	tsData.LastQueried = time.Now()
+
+	// Cache entry
+	cache.Lock()
+	cache.m[symbol] = tsData
+	cache.Unlock()
 	return nil
 }
 
-func getTsDailyAdj(symbol string, client qClient) (resp tsDailyAdjResp, err error) {
-	<-rateLimitOk
-	log.Print("Fetching daily adjusted time series data for symbol ", symbol)
+// isCached checks if data of `symbol` was queried today.
+func isCached(symbol string) bool {
+	cache.RLock()
+	tsData, entryFound := cache.m[symbol]
+	cache.RUnlock()
 
-	url := avURL(symbol, avAPIKey)
+	return entryFound && !tsData.LastQueried.Before(time.Now().Truncate(24*time.Hour))
+}
+
+// query waits for the rate limit and returns the body of the response of
+// AlphaVantage to a GET request of `url`.
+func query(client qClient, url string) ([]byte, error) {
+	<-rateLimitOk
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
 
 	res, err := client.Do(req)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	if res.Body != nil {
 		defer res.Body.Close()
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	return ioutil.ReadAll(res.Body)
+}
+
+func getTsDailyAdj(symbol string, client qClient) (resp tsDailyAdjResp, err error) {
+	log.Print("Fetching daily adjusted time series data for symbol ", symbol)
+
+	body, err := query(client, avURL(symbol, avAPIKey))
 	if err != nil {
 		return
 	}
@@ -196,6 +371,77 @@ func getTsDailyAdj(symbol string, client qClient) (resp tsDailyAdjResp, err erro
 	return
 }
 
+// getCPI fetches the monthly consumer price index. It is stored like a time
+// series with the index as prices to be cached along with the symbols.
+func getCPI(symbol string, client qClient) (resp tsDailyAdjResp, err error) {
+	log.Print("Fetching consumer price index")
+
+	body, err := query(client, cpiURL(avAPIKey))
+	if err != nil {
+		return
+	}
+
+	var cpi cpiResp
+	if err = json.Unmarshal(body, &cpi); err != nil {
+		return
+	}
+
+	if len(cpi.Data) == 0 {
+		err = errors.New("No data for the consumer price index found")
+		return
+	}
+
+	resp.MetaData.Information = cpi.Name
+	resp.MetaData.Symbol = symbol
+	resp.TimeSeries = make(map[string]tsDailyAdj)
+	for _, point := range cpi.Data {
+		resp.TimeSeries[point.Date] = tsDailyAdj{Close: point.Value, AdjustedClose: point.Value}
+	}
+	return
+}
+
+// getFX fetches the daily exchange rates of a symbol like `USDEUR=X`. They
+// are stored like a time series with the rates as prices to be cached along
+// with the symbols.
+func getFX(symbol string, client qClient) (resp tsDailyAdjResp, err error) {
+	pair := strings.TrimSuffix(symbol, fxSuffix)
+	if len(pair) != 6 {
+		err = errors.New(fmt.Sprint("Invalid currency pair ", symbol))
+		return
+	}
+	from, to := pair[:3], pair[3:]
+
+	log.Print("Fetching daily exchange rates from ", from, " to ", to)
+
+	body, err := query(client, fxURL(from, to, avAPIKey))
+	if err != nil {
+		return
+	}
+
+	var fx fxResp
+	if err = json.Unmarshal(body, &fx); err != nil {
+		return
+	}
+
+	if len(fx.TimeSeries) == 0 {
+		err = errors.New(fmt.Sprint("No exchange rates for ", symbol, " found"))
+		return
+	}
+
+	resp.MetaData.Symbol = symbol
+	resp.TimeSeries = make(map[string]tsDailyAdj)
+	for date, rate := range fx.TimeSeries {
+		resp.TimeSeries[date] = tsDailyAdj{
+			Open:          rate.Open,
+			High:          rate.High,
+			Low:           rate.Low,
+			Close:         rate.Close,
+			AdjustedClose: rate.Close,
+		}
+	}
+	return
+}
+
 func limitQueryRate(timeout time.Duration) {
 	for {
 		rateLimitOk <- true
@@ -1,8 +1,13 @@
 package av
 
 import (
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -15,6 +20,24 @@ func (m *mockClient) Do(req *http.Request) (*http.Response, error) {
 	return args.Get(0).(*http.Response), args.Error(1)
 }
 
+func TestGetCPI(t *testing.T) {
+	body := `{"name": "CPI", "data": [{"date": "2020-01-01", "value": "259.127"}]}`
+	testClient := new(mockClient)
+	testClient.On("Do", mock.Anything).Return(&http.Response{Body: ioutil.NopCloser(strings.NewReader(body))}, nil).Once()
+
+	// Let the query pass the rate limit
+	rateLimitOk <- true
+	resp, err := getCPI(CPISymbol, testClient)
+	assert.Nil(t, err)
+	assert.Equal(t, 259.127, resp.TimeSeries["2020-01-01"].AdjustedClose)
+
+	retErr := errors.New("Client call failed")
+	testClient.On("Do", mock.Anything).Return((*http.Response)(nil), retErr).Once()
+	rateLimitOk <- true
+	_, err = getCPI(CPISymbol, testClient)
+	assert.Equal(t, retErr, err, "Expected the error of the client call")
+}
+
 /*
 func TestGetTsDailyAdj(t *testing.T) {
 	// Overwrite query limit since the calls are mocked
@@ -0,0 +1,350 @@
+package csvdata
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgasse/finca/ohlc"
+)
+
+// Layouts of the date column which are tried in order when parsing a row.
+var dateLayouts = []string{"2006-01-02", "20060102", "2006/01/02", "02.01.2006"}
+
+// Aliases of column names in the headers of Yahoo, Stooq, AlphaVantage and
+// FRED CSV files, mapped to the field they fill. Header names are lowercased
+// and stripped of spaces, underscores and angle brackets before the lookup.
+var columnAliases = map[string]string{
+	"date":          "date",
+	"timestamp":     "date",
+	"data":          "date",
+	"open":          "open",
+	"otwarcie":      "open",
+	"high":          "high",
+	"najwyzszy":     "high",
+	"low":           "low",
+	"najnizszy":     "low",
+	"close":         "close",
+	"zamkniecie":    "close",
+	"adjclose":      "adjClose",
+	"adjustedclose": "adjClose",
+	// Values of series like the CPI
+	"value":    "close",
+	"cpiaucsl": "close",
+	"volume":   "volume",
+	"vol":      "volume",
+	"wolumen":  "volume",
+	// The close price has to be unadjusted for dividends and the splits of
+	// these columns
+	"dividend":         "dividend",
+	"dividends":        "dividend",
+	"dividendamount":   "dividend",
+	"split":            "split",
+	"splitcoefficient": "split",
+}
+
+type dailyBar struct {
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	AdjClose float64
+	Volume   float64
+	Dividend float64
+	Split    float64
+}
+
+type series struct {
+	// Sorted dates in the format `2006-01-02`
+	dates []string
+	bars  map[string]dailyBar
+}
+
+// CsvProvider provides prices and date ranges of symbols from CSV files with
+// daily end-of-day data. The data of every symbol is read from the file
+// `<symbol>.csv` in the directory of the provider when it is first requested.
+// Dividends and splits are read from optional columns like
+// `dividend_amount` and `split_coefficient`. Exchange rates are read like
+// prices from files named like `USDEUR=X.csv`.
+type CsvProvider struct {
+	dir   string
+	cache struct {
+		sync.RWMutex
+		m map[string]series
+	}
+}
+
+// NewCsvProvider creates a new provider reading CSV files from `dir`.
+func NewCsvProvider(dir string) *CsvProvider {
+	c := &CsvProvider{dir: dir}
+	c.cache.m = make(map[string]series)
+	return c
+}
+
+func (c *CsvProvider) GetPrice(symbol string, date time.Time) (float64, error) {
+	bar, err := c.getPriceBar(symbol, date)
+	return bar.AdjClose, err
+}
+
+// GetRawPrice returns the close price of `symbol` on `date` which is not
+// adjusted for dividends.
+func (c *CsvProvider) GetRawPrice(symbol string, date time.Time) (float64, error) {
+	bar, err := c.getPriceBar(symbol, date)
+	return bar.Close, err
+}
+
+// GetDividend returns the dividend per share of `symbol` going ex on exactly
+// `date`, 0 if there is none.
+func (c *CsvProvider) GetDividend(symbol string, date time.Time) (float64, error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return 0.0, err
+	}
+	return ts.bars[date.Format("2006-01-02")].Dividend, nil
+}
+
+// GetSplit returns the split coefficient of `symbol` on exactly `date`, 1 if
+// there is no split.
+func (c *CsvProvider) GetSplit(symbol string, date time.Time) (float64, error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return 1.0, err
+	}
+	if split := ts.bars[date.Format("2006-01-02")].Split; split != 0.0 {
+		return split, nil
+	}
+	return 1.0, nil
+}
+
+// GetBar returns the open, high, low and close prices of `symbol` on exactly
+// `date` and tells if the symbol traded on this date. Prices missing in the
+// file are zero.
+func (c *CsvProvider) GetBar(symbol string, date time.Time) (ohlc.Bar, bool, error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return ohlc.Bar{}, false, err
+	}
+
+	bar, ok := ts.bars[date.Format("2006-01-02")]
+	return ohlc.Bar{
+		Open:          bar.Open,
+		High:          bar.High,
+		Low:           bar.Low,
+		Close:         bar.Close,
+		AdjustedClose: bar.AdjClose,
+	}, ok, nil
+}
+
+// getPriceBar returns the bar of `symbol` on `date` or up to one week
+// previously.
+func (c *CsvProvider) getPriceBar(symbol string, date time.Time) (dailyBar, error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return dailyBar{}, err
+	}
+
+	// Check for price on the exact date or up to one week previously
+	for i := 0; i <= 7; i++ {
+		dateS := date.Add(-time.Duration(i) * 24 * time.Hour).Format("2006-01-02")
+		bar, ok := ts.bars[dateS]
+		if ok {
+			return bar, nil
+		}
+	}
+	return dailyBar{}, errors.New(fmt.Sprint("Could not find a price for ", symbol))
+}
+
+// GetSeries returns the adjusted close prices of `symbol` on all dates as
+// `2006-01-02`. This also reads series like the CPI.
+func (c *CsvProvider) GetSeries(symbol string) (map[string]float64, error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64, len(ts.dates))
+	for _, date := range ts.dates {
+		values[date] = ts.bars[date].AdjClose
+	}
+	return values, nil
+}
+
+func (c *CsvProvider) GetDateRange(symbol string) (earliest, latest string, err error) {
+	ts, err := c.getSeries(symbol)
+	if err != nil {
+		return
+	}
+
+	earliest = ts.dates[0]
+	latest = ts.dates[len(ts.dates)-1]
+	return
+}
+
+func (c *CsvProvider) getSeries(symbol string) (series, error) {
+	c.cache.RLock()
+	ts, ok := c.cache.m[symbol]
+	c.cache.RUnlock()
+	if ok {
+		return ts, nil
+	}
+
+	ts, err := c.loadSeries(symbol)
+	if err != nil {
+		return series{}, err
+	}
+
+	c.cache.Lock()
+	c.cache.m[symbol] = ts
+	c.cache.Unlock()
+	return ts, nil
+}
+
+func (c *CsvProvider) loadSeries(symbol string) (series, error) {
+	// Symbols come from requests and must not reach outside the directory
+	if symbol == "" || filepath.Base(symbol) != symbol || strings.Contains(symbol, "..") {
+		return series{}, fmt.Errorf("Invalid symbol %q", symbol)
+	}
+
+	fPath := filepath.Join(c.dir, symbol+".csv")
+	f, err := os.Open(fPath)
+	if err != nil {
+		return series{}, err
+	}
+	defer f.Close()
+
+	ts, err := parseSeries(f)
+	if err != nil {
+		return series{}, fmt.Errorf("%s: %v", fPath, err)
+	}
+	return ts, nil
+}
+
+func parseSeries(r io.Reader) (series, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return series{}, err
+	}
+
+	cols, err := detectColumns(header)
+	if err != nil {
+		return series{}, err
+	}
+
+	ts := series{bars: make(map[string]dailyBar)}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return series{}, err
+		}
+
+		date, bar, ok := parseRow(record, cols)
+		if !ok {
+			// Skip incomplete rows such as `null` entries of Yahoo
+			continue
+		}
+		if _, exists := ts.bars[date]; !exists {
+			ts.dates = append(ts.dates, date)
+		}
+		ts.bars[date] = bar
+	}
+
+	if len(ts.dates) == 0 {
+		return series{}, errors.New("No valid rows found")
+	}
+	sort.Strings(ts.dates)
+	return ts, nil
+}
+
+func detectColumns(header []string) (map[string]int, error) {
+	cols := make(map[string]int)
+	for i, name := range header {
+		if field, ok := columnAliases[normalizeColumn(name)]; ok {
+			cols[field] = i
+		}
+	}
+
+	if _, ok := cols["date"]; !ok {
+		return nil, errors.New("No date column found")
+	}
+	if _, ok := cols["close"]; !ok {
+		if _, ok := cols["adjClose"]; !ok {
+			return nil, errors.New("No close column found")
+		}
+	}
+	return cols, nil
+}
+
+func normalizeColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	// Strip a byte order mark in the first column
+	name = strings.TrimPrefix(name, "\ufeff")
+	return strings.NewReplacer(" ", "", "_", "", "<", "", ">", "").Replace(name)
+}
+
+func parseRow(record []string, cols map[string]int) (date string, bar dailyBar, ok bool) {
+	field := func(name string) (float64, bool) {
+		i, exists := cols[name]
+		if !exists || i >= len(record) {
+			return 0.0, false
+		}
+		val, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+		return val, err == nil
+	}
+
+	if cols["date"] >= len(record) {
+		return
+	}
+	parsed, err := parseDate(strings.TrimSpace(record[cols["date"]]))
+	if err != nil {
+		return
+	}
+	date = parsed.Format("2006-01-02")
+
+	closeP, hasClose := field("close")
+	adjClose, hasAdj := field("adjClose")
+	if !hasClose && !hasAdj {
+		return
+	}
+	if !hasClose {
+		closeP = adjClose
+	}
+	if !hasAdj {
+		// Stooq data is already adjusted for splits and dividends
+		adjClose = closeP
+	}
+
+	bar = dailyBar{Close: closeP, AdjClose: adjClose}
+	bar.Open, _ = field("open")
+	bar.High, _ = field("high")
+	bar.Low, _ = field("low")
+	bar.Volume, _ = field("volume")
+	bar.Dividend, _ = field("dividend")
+	bar.Split, _ = field("split")
+	ok = true
+	return
+}
+
+func parseDate(s string) (date time.Time, err error) {
+	for _, layout := range dateLayouts {
+		date, err = time.Parse(layout, s)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
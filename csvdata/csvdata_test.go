@@ -0,0 +1,176 @@
+package csvdata
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yahooCSV = `Date,Open,High,Low,Close,Adj Close,Volume
+1993-01-29,43.968750,43.968750,43.750000,43.937500,25.218683,1003200
+1993-02-01,43.968750,44.250000,43.968750,44.250000,25.398039,480500
+1993-02-02,null,null,null,null,null,null
+1993-02-03,44.406250,44.843750,44.375000,44.812500,25.720911,529400
+`
+
+const stooqCSV = `Date,Open,High,Low,Close,Volume
+1970-01-02,92.06,93.54,91.79,93.0,8050000
+1970-01-05,93.0,94.25,92.53,93.46,11490000
+`
+
+const stooqASCII = `<TICKER>,<PER>,<DATE>,<TIME>,<OPEN>,<HIGH>,<LOW>,<CLOSE>,<VOL>,<OPENINT>
+^SPX,D,19700102,000000,92.06,93.54,91.79,93.0,8050000,0
+^SPX,D,19700105,000000,93.0,94.25,92.53,93.46,11490000,0
+`
+
+func TestParseSeriesYahoo(t *testing.T) {
+	ts, err := parseSeries(strings.NewReader(yahooCSV))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1993-01-29", "1993-02-01", "1993-02-03"}, ts.dates,
+		"Rows with null entries should be skipped")
+	assert.Equal(t, 25.398039, ts.bars["1993-02-01"].AdjClose, "Adjusted close wrong")
+	assert.Equal(t, 44.25, ts.bars["1993-02-01"].Close, "Close wrong")
+}
+
+func TestParseSeriesStooq(t *testing.T) {
+	for _, data := range []string{stooqCSV, stooqASCII} {
+		ts, err := parseSeries(strings.NewReader(data))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1970-01-02", "1970-01-05"}, ts.dates, "Dates wrong")
+		assert.Equal(t, 93.46, ts.bars["1970-01-05"].AdjClose,
+			"Adjusted close should fall back to close")
+		assert.Equal(t, 92.53, ts.bars["1970-01-05"].Low, "Low wrong")
+	}
+}
+
+func TestParseSeriesInvalid(t *testing.T) {
+	_, err := parseSeries(strings.NewReader("Foo,Bar\n1,2\n"))
+	assert.NotNil(t, err, "Expected an error for a missing date column")
+
+	_, err = parseSeries(strings.NewReader("Date,Close\n"))
+	assert.NotNil(t, err, "Expected an error for a file without rows")
+}
+
+func TestCsvProvider(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(dir, "SPY.csv"), []byte(yahooCSV), 0644)
+	assert.Nil(t, err)
+
+	c := NewCsvProvider(dir)
+
+	earliest, latest, err := c.GetDateRange("SPY")
+	assert.Nil(t, err)
+	assert.Equal(t, "1993-01-29", earliest)
+	assert.Equal(t, "1993-02-03", latest)
+
+	// Fall back to the last known price on days without data
+	price, err := c.GetPrice("SPY", time.Date(1993, 2, 2, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 25.398039, price)
+
+	_, err = c.GetPrice("SPY", time.Date(1993, 1, 1, 12, 0, 0, 0, time.UTC))
+	assert.NotNil(t, err, "Expected an error before the first date")
+
+	_, err = c.GetPrice("MISSING", time.Date(1993, 2, 1, 12, 0, 0, 0, time.UTC))
+	assert.NotNil(t, err, "Expected an error for a missing file")
+
+	// Files outside of the directory cannot be read
+	outside := t.TempDir()
+	err = ioutil.WriteFile(path.Join(outside, "SECRET.csv"), []byte(yahooCSV), 0644)
+	assert.Nil(t, err)
+	for _, symbol := range []string{"../" + path.Base(outside) + "/SECRET", path.Join(outside, "SECRET"), ".."} {
+		_, _, err = c.GetDateRange(symbol)
+		assert.NotNil(t, err, "Expected an error for the symbol %q", symbol)
+	}
+
+	bar, ok, err := c.GetBar("SPY", time.Date(1993, 2, 1, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 43.96875, bar.Open)
+	assert.Equal(t, 44.25, bar.High)
+	assert.Equal(t, 25.398039, bar.AdjustedClose)
+
+	_, ok, err = c.GetBar("SPY", time.Date(1993, 2, 2, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.False(t, ok, "Bars should not fall back to previous days")
+}
+
+const avCSV = `timestamp,open,high,low,close,adjusted_close,volume,dividend_amount,split_coefficient
+2020-08-28,502.14,505.77,498.31,499.23,124.81,46907479,0.0000,1.0
+2020-08-31,127.58,131.00,126.00,129.04,129.04,225702688,0.0000,4.0
+2020-11-06,118.32,119.20,116.13,118.69,118.49,114457922,0.2050,1.0
+`
+
+func TestCsvProviderDividends(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(path.Join(dir, "AAPL.csv"), []byte(avCSV), 0644)
+	assert.Nil(t, err)
+
+	c := NewCsvProvider(dir)
+
+	exDate := time.Date(2020, 11, 6, 12, 0, 0, 0, time.UTC)
+	price, err := c.GetRawPrice("AAPL", exDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 118.69, price, "Raw price should be the close")
+
+	dividend, err := c.GetDividend("AAPL", exDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.205, dividend)
+
+	dividend, err = c.GetDividend("AAPL", exDate.AddDate(0, 0, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, dividend, "Dividends should only be paid on the exact date")
+
+	split, err := c.GetSplit("AAPL", time.Date(2020, 8, 31, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, split)
+
+	split, err = c.GetSplit("AAPL", exDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, split)
+}
+
+func TestCsvProviderSeries(t *testing.T) {
+	dir := t.TempDir()
+	fred := "DATE,CPIAUCSL\n2020-01-01,259.127\n2020-02-01,259.250\n"
+	err := ioutil.WriteFile(path.Join(dir, "$CPI.csv"), []byte(fred), 0644)
+	assert.Nil(t, err)
+
+	values, err := NewCsvProvider(dir).GetSeries("$CPI")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"2020-01-01": 259.127, "2020-02-01": 259.25}, values)
+}
+
+const brokerCSV = `Trade Date,ISIN,Symbol,Quantity,Price,Commission
+15.03.2020,IE00B4L5Y983,EUNL.DE,10,45.5,4.9
+2020-01-14,IE00B4L5Y983,EUNL.DE,5,55.0,4.9
+2020-06-15,IE00B4L5Y983,,-3,50.0,
+`
+
+func TestParseTrades(t *testing.T) {
+	trades, err := parseTrades(strings.NewReader(brokerCSV))
+	assert.Nil(t, err)
+	assert.Len(t, trades, 3)
+
+	assert.Equal(t, time.Date(2020, 1, 14, 12, 0, 0, 0, time.UTC), trades[0].Date, "Trades should be sorted by date")
+	assert.Equal(t, 5.0, trades[0].Shares)
+	assert.Equal(t, 45.5, trades[1].Price)
+	assert.Equal(t, 4.9, trades[1].Fees)
+	assert.Equal(t, "EUNL.DE", trades[2].Symbol, "The symbol should be looked up by the ISIN")
+	assert.Equal(t, -3.0, trades[2].Shares)
+	assert.Equal(t, 0.0, trades[2].Fees)
+
+	for _, data := range []string{
+		"Date,Shares,Price\n2020-01-14,5,55.0\n",
+		"Date,Symbol,Shares,Price\n2020-01-14,SPY,five,55.0\n",
+		"Date,ISIN,Shares,Price\n2020-01-14,IE00B4L5Y983,5,55.0\n",
+		"Date,Symbol,Shares,Price\n2020-01-14,SPY,5,55.0\n2020-01-15,SPY,-6,55.0\n",
+	} {
+		_, err := parseTrades(strings.NewReader(data))
+		assert.NotNil(t, err, "Expected an error for %q", data)
+	}
+}
@@ -0,0 +1,156 @@
+package csvdata
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sgasse/finca/sim"
+)
+
+// Aliases of column names in the transaction histories of brokers, mapped to
+// the field of `sim.Trade` they fill. Header names are normalized like those
+// of price files.
+var tradeColumnAliases = map[string]string{
+	"date":       "date",
+	"tradedate":  "date",
+	"symbol":     "symbol",
+	"ticker":     "symbol",
+	"isin":       "isin",
+	"currency":   "currency",
+	"shares":     "shares",
+	"quantity":   "shares",
+	"units":      "shares",
+	"price":      "price",
+	"fees":       "fees",
+	"fee":        "fees",
+	"commission": "fees",
+}
+
+// ReadTrades reads the trades of a real portfolio from a CSV file with the
+// columns `date`, `symbol` or `isin`, `shares`, `price` and optionally `fees`
+// and `currency`. Sales have negative shares. Rows with only an ISIN take the
+// symbol of another row with the same ISIN. The trades are sorted by date.
+func ReadTrades(path string) ([]sim.Trade, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	trades, err := parseTrades(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return trades, nil
+}
+
+func parseTrades(r io.Reader) ([]sim.Trade, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int)
+	for i, name := range header {
+		if field, ok := tradeColumnAliases[normalizeColumn(name)]; ok {
+			cols[field] = i
+		}
+	}
+	for _, field := range []string{"date", "shares", "price"} {
+		if _, ok := cols[field]; !ok {
+			return nil, fmt.Errorf("No %s column found", field)
+		}
+	}
+	_, hasSymbol := cols["symbol"]
+	_, hasISIN := cols["isin"]
+	if !hasSymbol && !hasISIN {
+		return nil, errors.New("No symbol or ISIN column found")
+	}
+
+	var trades []sim.Trade
+	isinSymbols := make(map[string]string)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		trade, err := parseTrade(record, cols)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if trade.ISIN != "" && trade.Symbol != "" {
+			isinSymbols[trade.ISIN] = trade.Symbol
+		}
+		trades = append(trades, trade)
+	}
+
+	for i, trade := range trades {
+		if trade.Symbol == "" {
+			symbol, ok := isinSymbols[trade.ISIN]
+			if !ok {
+				return nil, fmt.Errorf("No symbol for ISIN %q", trade.ISIN)
+			}
+			trades[i].Symbol = symbol
+		}
+	}
+
+	sim.SortTrades(trades)
+	if err := sim.ValidateTrades(trades); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+func parseTrade(record []string, cols map[string]int) (trade sim.Trade, err error) {
+	text := func(name string) string {
+		i, exists := cols[name]
+		if !exists || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(name string) (float64, error) {
+		s := text(name)
+		if s == "" {
+			return 0.0, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+
+	date, err := parseDate(text("date"))
+	if err != nil {
+		return trade, fmt.Errorf("Invalid date %q", text("date"))
+	}
+	// Simulations run at noon
+	trade.Date = date.Add(12 * time.Hour)
+
+	trade.Symbol = text("symbol")
+	trade.ISIN = text("isin")
+	trade.Currency = text("currency")
+	if trade.Symbol == "" && trade.ISIN == "" {
+		return trade, errors.New("No symbol or ISIN given")
+	}
+
+	if trade.Shares, err = number("shares"); err != nil {
+		return
+	}
+	if trade.Price, err = number("price"); err != nil {
+		return
+	}
+	trade.Fees, err = number("fees")
+	return
+}
@@ -1,18 +1,110 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/sgasse/finca/analyze"
 	"github.com/sgasse/finca/av"
+	"github.com/sgasse/finca/csvdata"
 )
 
+const usage = `Usage: finca <command> [flags]
+
+Commands:
+  serve     Start the web server with charts (default)
+  simulate  Simulate strategies and print the results
+  fetch     Download the latest data of symbols from AlphaVantage
+
+Run 'finca <command> -h' for the flags of a command.
+`
+
 func main() {
+	cmd := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = serve(args)
+	case "simulate":
+		err = simulate(args)
+	case "fetch":
+		err = fetch(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	csvDir := fs.String("csv", os.Getenv("FINCA_CSV_DIR"), "directory with CSV price files")
+	fs.Parse(args)
+
+	priceP, err := launchProvider(*csvDir)
+	if err != nil {
+		return err
+	}
+	analyze.LaunchVisualizer(priceP)
+	return nil
+}
+
+func fetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: finca fetch SYMBOL...")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := launchProvider(""); err != nil {
+		return err
+	}
+	defer av.SaveCache()
+
+	for _, symbol := range fs.Args() {
+		if err := av.Fetch(symbol); err != nil {
+			return fmt.Errorf("%s: %v", symbol, err)
+		}
+		earliest, latest, err := av.GetDateRange(symbol)
+		if err != nil {
+			return fmt.Errorf("%s: %v", symbol, err)
+		}
+		fmt.Println(symbol, ":", earliest, "-", latest)
+	}
+	return nil
+}
+
+// launchProvider returns a provider reading CSV files from `csvDir` or,
+// if `csvDir` is empty, a provider querying AlphaVantage with the API key
+// from the environment variable `AV_API_KEY`.
+func launchProvider(csvDir string) (analyze.PriceProvider, error) {
+	if csvDir != "" {
+		log.Println("Reading prices from CSV files in ", csvDir)
+		return csvdata.NewCsvProvider(csvDir), nil
+	}
+
 	avAPIKey := os.Getenv("AV_API_KEY")
 	if avAPIKey == "" {
-		log.Fatal("You must specify your API key from AlphaVantage as AV_API_KEY.")
+		return nil, errors.New("You must specify your API key from AlphaVantage as AV_API_KEY or a directory with CSV files as FINCA_CSV_DIR.")
 	}
 	av.LaunchAV(avAPIKey)
-	analyze.LaunchVisualizer()
+	return &av.AvProvider{}, nil
 }
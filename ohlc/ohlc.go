@@ -0,0 +1,14 @@
+// Package ohlc holds the daily prices of a symbol which the data sources
+// provide and the simulator executes trades at, so that the data sources do
+// not depend on the simulator.
+package ohlc
+
+// A Bar holds the prices of a symbol on one trading day. Open, high, low and
+// close are not adjusted for dividends, the adjusted close is.
+type Bar struct {
+	Open          float64
+	High          float64
+	Low           float64
+	Close         float64
+	AdjustedClose float64
+}
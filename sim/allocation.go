@@ -0,0 +1,113 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An Allocation is the share of a symbol in the value of a portfolio.
+type Allocation struct {
+	Symbol string  `json:"symbol" yaml:"symbol"`
+	Weight float64 `json:"weight" yaml:"weight"`
+	// Currency the symbol is quoted in if it differs from the currency of
+	// the investor
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+}
+
+// ParseAllocations parses a weighted list of symbols like `VTI:0.6,BND:0.4`.
+// A single symbol without weight gets the full weight. The currency a symbol
+// is quoted in can be appended like `VTI@USD:0.6`.
+func ParseAllocations(list string) ([]Allocation, error) {
+	var allocs []Allocation
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		alloc := Allocation{Symbol: parts[0], Weight: 1.0}
+		if i := strings.LastIndex(alloc.Symbol, "@"); i >= 0 {
+			alloc.Symbol, alloc.Currency = alloc.Symbol[:i], alloc.Symbol[i+1:]
+		}
+		if len(parts) == 2 {
+			weight, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid weight of %s: %v", parts[0], err)
+			}
+			alloc.Weight = weight
+		}
+		allocs = append(allocs, alloc)
+	}
+
+	if err := ValidateAllocations(allocs); err != nil {
+		return nil, err
+	}
+	return allocs, nil
+}
+
+// ValidateAllocations checks that all symbols are given once with a positive
+// weight and that the weights sum up to 1.
+func ValidateAllocations(allocs []Allocation) error {
+	if len(allocs) == 0 {
+		return errors.New("No symbols given")
+	}
+
+	symbols := make(map[string]bool)
+	weightSum := 0.0
+	for _, alloc := range allocs {
+		if alloc.Symbol == "" {
+			return errors.New("Empty symbol")
+		}
+		if symbols[alloc.Symbol] {
+			return fmt.Errorf("Duplicate symbol %s", alloc.Symbol)
+		}
+		symbols[alloc.Symbol] = true
+
+		if alloc.Weight <= 0.0 {
+			return fmt.Errorf("Weight of %s must be positive", alloc.Symbol)
+		}
+		if err := ValidateCurrency(alloc.Currency); err != nil {
+			return fmt.Errorf("%s: %v", alloc.Symbol, err)
+		}
+		weightSum += alloc.Weight
+	}
+
+	if math.Abs(weightSum-1.0) > 1e-6 {
+		return fmt.Errorf("Weights sum up to %v instead of 1", weightSum)
+	}
+	return nil
+}
+
+// FormatAllocations formats allocations in the format read by
+// `ParseAllocations`. A single symbol is given without weight.
+func FormatAllocations(allocs []Allocation) string {
+	var items []string
+	for _, alloc := range allocs {
+		item := alloc.Symbol
+		if alloc.Currency != "" {
+			item += "@" + alloc.Currency
+		}
+		if len(allocs) > 1 {
+			item += ":" + strconv.FormatFloat(alloc.Weight, 'f', -1, 64)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ",")
+}
+
+// NewAllocationPortfolio creates an empty portfolio holding the symbols of
+// `allocs` which is rebalanced towards their weights.
+func NewAllocationPortfolio(allocs []Allocation, priceS PriceSource, fees FeeModel, opts ...PortfolioOption) (Portfolio, error) {
+	if err := ValidateAllocations(allocs); err != nil {
+		return nil, err
+	}
+
+	stocks := make(map[*Stock]float64)
+	goalRatios := make(map[*Stock]float64)
+	for _, alloc := range allocs {
+		stock := &Stock{Symbol: alloc.Symbol, Currency: alloc.Currency}
+		stocks[stock] = 0
+		goalRatios[stock] = alloc.Weight
+	}
+
+	return NewMultiPortfolio(priceS, 0.0, stocks, goalRatios, fees, opts...)
+}
@@ -0,0 +1,37 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAllocations(t *testing.T) {
+	allocs, err := ParseAllocations("VTI:0.6, BND:0.4")
+	assert.Nil(t, err)
+	assert.Equal(t, []Allocation{{Symbol: "VTI", Weight: 0.6}, {Symbol: "BND", Weight: 0.4}}, allocs)
+	assert.Equal(t, "VTI:0.6,BND:0.4", FormatAllocations(allocs))
+
+	allocs, err = ParseAllocations("SPY")
+	assert.Nil(t, err)
+	assert.Equal(t, []Allocation{{Symbol: "SPY", Weight: 1.0}}, allocs)
+	assert.Equal(t, "SPY", FormatAllocations(allocs))
+
+	allocs, err = ParseAllocations("VTI@USD:0.6,EUNL.DE:0.4")
+	assert.Nil(t, err)
+	assert.Equal(t, []Allocation{{Symbol: "VTI", Weight: 0.6, Currency: "USD"}, {Symbol: "EUNL.DE", Weight: 0.4}}, allocs)
+	assert.Equal(t, "VTI@USD:0.6,EUNL.DE:0.4", FormatAllocations(allocs))
+
+	for _, list := range []string{
+		"",
+		"VTI:0.6",
+		"VTI:0.6,BND",
+		"VTI:abc,BND:0.4",
+		"VTI:0.6,VTI:0.4",
+		"VTI:1.2,BND:-0.2",
+		"VTI@usd",
+	} {
+		_, err := ParseAllocations(list)
+		assert.NotNil(t, err, list)
+	}
+}
@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"time"
+)
+
+// A Calendar tells on which days an exchange trades. Simulations only step
+// through trading days, so strategies and income never trade on stale prices
+// of days the exchange was closed.
+type Calendar interface {
+	IsTradingDay(time.Time) bool
+}
+
+// A WeekdayCalendar trades from Monday to Friday. It is used if no other
+// calendar is given.
+type WeekdayCalendar struct{}
+
+func (WeekdayCalendar) IsTradingDay(date time.Time) bool {
+	return date.Weekday() != time.Saturday && date.Weekday() != time.Sunday
+}
+
+// A SeriesCalendar trades on the dates of price series, which leaves out the
+// holidays of their exchange. Outside of the range of the series, it trades
+// on weekdays.
+type SeriesCalendar struct {
+	// Trading days by `dayKey`
+	days  map[int]bool
+	first int
+	last  int
+}
+
+// NewSeriesCalendar creates a calendar trading on the dates common to all
+// `series`, given by dates as `2006-01-02`.
+func NewSeriesCalendar(series ...map[string]float64) *SeriesCalendar {
+	c := &SeriesCalendar{days: make(map[int]bool)}
+	if len(series) == 0 {
+		return c
+	}
+
+	for date := range series[0] {
+		common := true
+		for _, s := range series[1:] {
+			if _, ok := s[date]; !ok {
+				common = false
+				break
+			}
+		}
+		if !common {
+			continue
+		}
+
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		day := dayKey(d)
+		c.days[day] = true
+		if c.first == 0 || day < c.first {
+			c.first = day
+		}
+		if day > c.last {
+			c.last = day
+		}
+	}
+	return c
+}
+
+func (c *SeriesCalendar) IsTradingDay(date time.Time) bool {
+	day := dayKey(date)
+	if len(c.days) == 0 || day < c.first || day > c.last {
+		return WeekdayCalendar{}.IsTradingDay(date)
+	}
+	return c.days[day]
+}
+
+// dayKey identifies the day of `date` by a number like 20060102, which is
+// much cheaper to calculate than a formatted date.
+func dayKey(date time.Time) int {
+	year, month, day := date.Date()
+	return year*10000 + int(month)*100 + day
+}
+
+// tradingDays returns all trading days of `cal` from `start` up to and
+// including `end`.
+func tradingDays(cal Calendar, start time.Time, end time.Time) (days []time.Time) {
+	for day := start; !day.After(end); day = day.Add(24 * time.Hour) {
+		if cal.IsTradingDay(day) {
+			days = append(days, day)
+		}
+	}
+	return
+}
+
+// isLastTradingDayOfMonth checks if `cal` has no trading day after `date` in
+// the month of `date`.
+func isLastTradingDayOfMonth(cal Calendar, date time.Time) bool {
+	for day := date.Add(24 * time.Hour); day.Month() == date.Month(); day = day.Add(24 * time.Hour) {
+		if cal.IsTradingDay(day) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithCalendar trades the portfolio on the trading days of `cal`, e.g. at the
+// open of the next trading day. By default, it trades on weekdays.
+func WithCalendar(cal Calendar) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.calendar = cal
+	}
+}
+
+// nextTradingDay returns the first trading day of the portfolio after `date`
+// within `maxDays` days.
+func (p *multiPortfolio) nextTradingDay(date time.Time, maxDays int) (time.Time, bool) {
+	cal := p.calendar
+	if cal == nil {
+		cal = WeekdayCalendar{}
+	}
+	for i := 1; i <= maxDays; i++ {
+		if day := date.AddDate(0, 0, i); cal.IsTradingDay(day) {
+			return day, true
+		}
+	}
+	return time.Time{}, false
+}
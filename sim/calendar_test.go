@@ -0,0 +1,66 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSeriesCalendar(t *testing.T) {
+	cal := NewSeriesCalendar(
+		map[string]float64{"2021-03-31": 1.0, "2021-04-01": 1.0, "2021-04-05": 1.0, "2021-04-06": 1.0},
+		// Good Friday and Easter Monday are missing in one of the series
+		map[string]float64{"2021-03-31": 1.0, "2021-04-01": 1.0, "2021-04-06": 1.0},
+	)
+
+	day := func(d int) time.Time { return time.Date(2021, 4, d, 12, 0, 0, 0, time.UTC) }
+	assert.True(t, cal.IsTradingDay(day(1)))
+	assert.False(t, cal.IsTradingDay(day(2)), "Holidays should not be trading days")
+	assert.False(t, cal.IsTradingDay(day(5)), "Only common dates should be trading days")
+	assert.True(t, cal.IsTradingDay(day(6)))
+	assert.True(t, cal.IsTradingDay(day(7)), "Weekdays after the series should be trading days")
+	assert.False(t, cal.IsTradingDay(day(10)))
+
+	assert.Equal(t, []time.Time{day(1), day(6)}, tradingDays(cal, day(1), day(6)))
+	assert.True(t, isLastTradingDayOfMonth(cal, day(30)))
+	assert.False(t, isLastTradingDayOfMonth(cal, day(29)))
+}
+
+func TestSimulateTradingDays(t *testing.T) {
+	// The simulation starts on a Sunday and the 14th of August is a Saturday
+	start := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 9, 30, 12, 0, 0, 0, time.UTC)
+	exDate := time.Date(2021, 8, 21, 12, 0, 0, 0, time.UTC)
+
+	ds := &mockDividendSource{}
+	ds.On("GetRawPrice", "DIST", mock.Anything).Return(100.0, nil)
+	ds.On("GetDividend", "DIST", exDate).Return(2.0, nil)
+	ds.On("GetDividend", "DIST", mock.Anything).Return(0.0, nil)
+	ds.On("GetSplit", "DIST", mock.Anything).Return(1.0, nil)
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "DIST", Weight: 1.0}},
+		PriceS:    ds,
+		Income:    IncomeSpec{Monthly: 1000.0},
+		Options:   []PortfolioOption{WithDividends(ds, KeepDividends)},
+	}
+	res, err := SimulateStratOnRef(cfg, NewMonthlyStrategy(start))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2021/08/31", "2021/09/30"}, res.Dates)
+
+	var dates []string
+	for _, entry := range res.Ledger {
+		dates = append(dates, entry.Type+" "+entry.Date)
+	}
+	assert.Equal(t, []string{
+		"income 2021-08-02",
+		"buy 2021-08-16",
+		"dividend 2021-08-21",
+		"income 2021-09-01",
+		"buy 2021-09-14",
+	}, dates, "Transactions should only happen on weekdays except for ex-dates")
+}
@@ -0,0 +1,100 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+)
+
+// An FXSource provides exchange rates between currencies on a given date.
+type FXSource interface {
+	// GetRate returns the price of one unit of the first currency in the
+	// second currency.
+	GetRate(string, string, time.Time) (float64, error)
+}
+
+// FXSymbol returns the symbol under which the price of one unit of `from` in
+// `to` is provided like the price of a stock, e.g. `USDEUR=X` as on Yahoo.
+func FXSymbol(from string, to string) string {
+	return from + to + "=X"
+}
+
+// priceFX is an `FXSource` looking up exchange rates as the prices of the
+// symbols of `FXSymbol`.
+type priceFX struct {
+	priceS PriceSource
+}
+
+func (f priceFX) GetRate(from string, to string, date time.Time) (float64, error) {
+	return f.priceS.GetPrice(FXSymbol(from, to), date)
+}
+
+// WithCurrency values and trades the portfolio in `currency`, the currency of
+// the investor. Prices and dividends of stocks quoted in another currency are
+// converted with the rates of `fx` on the day of the transaction or
+// valuation. Income, withdrawals, fees and taxes are in `currency`. Without a
+// currency, prices are used as they are.
+func WithCurrency(fx FXSource, currency string) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.fx = fx
+		p.currency = currency
+	}
+}
+
+// CurrencyOption returns the portfolio option converting prices into
+// `currency` with the exchange rates of `priceS`. If `priceS` is no
+// `FXSource`, the rates are looked up as the prices of the symbols of
+// `FXSymbol`.
+func CurrencyOption(priceS PriceSource, currency string) (PortfolioOption, error) {
+	if err := ValidateCurrency(currency); err != nil {
+		return nil, err
+	}
+	fx, ok := priceS.(FXSource)
+	if !ok {
+		fx = priceFX{priceS}
+	}
+	return WithCurrency(fx, currency), nil
+}
+
+// ValidateCurrency checks that `code` is empty or a currency code of three
+// upper case letters like `EUR`.
+func ValidateCurrency(code string) error {
+	if code == "" {
+		return nil
+	}
+	if len(code) != 3 {
+		return fmt.Errorf("Invalid currency %q", code)
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return fmt.Errorf("Invalid currency %q", code)
+		}
+	}
+	return nil
+}
+
+// price returns the price of `stock` on `date` in the currency of the
+// portfolio.
+func (p *multiPortfolio) price(stock *Stock, date time.Time) (float64, error) {
+	price, err := p.priceS.GetPrice(stock.Symbol, date)
+	if err != nil {
+		return 0.0, err
+	}
+	return p.convert(price, stock, date)
+}
+
+// convert converts `amount` in the quote currency of `stock` into the
+// currency of the portfolio with the exchange rate on `date`.
+func (p *multiPortfolio) convert(amount float64, stock *Stock, date time.Time) (float64, error) {
+	if p.currency == "" || stock.Currency == "" || stock.Currency == p.currency {
+		return amount, nil
+	}
+	if p.fx == nil {
+		return 0.0, fmt.Errorf("No exchange rates to convert %s into %s", stock.Currency, p.currency)
+	}
+
+	rate, err := p.fx.GetRate(stock.Currency, p.currency, date)
+	if err != nil {
+		return 0.0, fmt.Errorf("No exchange rate from %s to %s: %v", stock.Currency, p.currency, err)
+	}
+	return amount * rate, nil
+}
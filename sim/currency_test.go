@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencyConversion(t *testing.T) {
+	buyDate := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	valDate := buyDate.AddDate(0, 1, 0)
+	sUS := &Stock{Symbol: "US", Currency: "USD"}
+	sEU := &Stock{Symbol: "EU", Currency: "EUR"}
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "US", buyDate).Return(100.0, nil)
+	priceP.On("GetPrice", "EU", buyDate).Return(50.0, nil)
+	priceP.On("GetPrice", "USDEUR=X", buyDate).Return(0.9, nil)
+	// Stock prices are unchanged while the dollar falls
+	priceP.On("GetPrice", "US", valDate).Return(100.0, nil)
+	priceP.On("GetPrice", "EU", valDate).Return(50.0, nil)
+	priceP.On("GetPrice", "USDEUR=X", valDate).Return(0.8, nil)
+
+	opt, err := CurrencyOption(priceP, "EUR")
+	assert.Nil(t, err)
+	p, err := NewMultiPortfolio(
+		priceP,
+		1000.0,
+		map[*Stock]float64{sUS: 0, sEU: 0},
+		map[*Stock]float64{sUS: 0.5, sEU: 0.5},
+		nil,
+		opt,
+	)
+	assert.Nil(t, err)
+
+	assert.Nil(t, p.rebalance(1000.0, buyDate))
+	mp := p.(*multiPortfolio)
+	assert.Equal(t, 5.0, mp.stocks[sUS], "Shares of US should cost 90 EUR")
+	assert.Equal(t, 10.0, mp.stocks[sEU])
+	value, err := p.TotalValue(buyDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000.0, value)
+	value, err = p.TotalValue(valDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000.0-50.0, value, "Value should drop with the dollar")
+
+	// Without a currency of the investor, prices are used as they are
+	p, err = NewMultiPortfolio(priceP, 0.0, map[*Stock]float64{sUS: 1}, map[*Stock]float64{sUS: 1.0}, nil)
+	assert.Nil(t, err)
+	value, err = p.TotalValue(buyDate)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, value)
+
+	_, err = CurrencyOption(priceP, "euro")
+	assert.NotNil(t, err)
+}
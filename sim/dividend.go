@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A DividendSource provides prices which are not adjusted for dividends
+// together with the dividends and splits of symbols. Simulating on raw prices
+// with dividends shows the effect of distributions which adjusted prices
+// assume to be reinvested for free.
+type DividendSource interface {
+	PriceSource
+	// GetRawPrice returns the close price of a symbol on a given date which is
+	// not adjusted for dividends.
+	GetRawPrice(string, time.Time) (float64, error)
+	// GetDividend returns the dividend per share of a symbol going ex on
+	// exactly the given date, 0 if there is none.
+	GetDividend(string, time.Time) (float64, error)
+	// GetSplit returns the split coefficient of a symbol on exactly the given
+	// date, 1 if there is no split.
+	GetSplit(string, time.Time) (float64, error)
+}
+
+// A DividendMode determines how dividends are handled in a simulation.
+type DividendMode int
+
+const (
+	// AdjustedPrices simulates on prices adjusted for dividends, assuming
+	// that dividends are reinvested without costs.
+	AdjustedPrices DividendMode = iota
+	// ReinvestDividends pays dividends into the cash of the portfolio and
+	// reinvests them right away.
+	ReinvestDividends
+	// KeepDividends pays dividends into the cash of the portfolio where they
+	// are invested with the next investment of the strategy.
+	KeepDividends
+	// PayOutDividends pays dividends out to the investor.
+	PayOutDividends
+)
+
+var dividendModeNames = map[DividendMode]string{
+	AdjustedPrices:    "adjusted",
+	ReinvestDividends: "reinvest",
+	KeepDividends:     "cash",
+	PayOutDividends:   "payout",
+}
+
+func (mode DividendMode) String() string {
+	return dividendModeNames[mode]
+}
+
+// ParseDividendMode returns the mode named `adjusted`, `reinvest`, `cash` or
+// `payout`. An empty name is the mode `AdjustedPrices`.
+func ParseDividendMode(name string) (DividendMode, error) {
+	if name == "" {
+		return AdjustedPrices, nil
+	}
+	for mode, modeName := range dividendModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return AdjustedPrices, fmt.Errorf("Unknown dividend mode %q", name)
+}
+
+// WithDividends values and trades the portfolio at the raw prices of `ds` and
+// handles the dividends of its stocks according to `mode`. With the mode
+// `AdjustedPrices`, the portfolio is left unchanged.
+func WithDividends(ds DividendSource, mode DividendMode) PortfolioOption {
+	return func(p *multiPortfolio) {
+		if mode == AdjustedPrices {
+			return
+		}
+		p.priceS = rawPrices{ds}
+		p.dividends = ds
+		p.dividendMode = mode
+	}
+}
+
+// DividendOption returns the portfolio option handling dividends in `mode`
+// with data of `priceS`, which has to be a `DividendSource` unless the mode
+// is `AdjustedPrices`.
+func DividendOption(priceS PriceSource, mode DividendMode) (PortfolioOption, error) {
+	if mode == AdjustedPrices {
+		return WithDividends(nil, mode), nil
+	}
+	ds, ok := priceS.(DividendSource)
+	if !ok {
+		return nil, errors.New("The price source provides no dividends")
+	}
+	return WithDividends(ds, mode), nil
+}
+
+// rawPrices is a `PriceSource` of prices not adjusted for dividends.
+type rawPrices struct {
+	ds DividendSource
+}
+
+func (r rawPrices) GetPrice(symbol string, date time.Time) (float64, error) {
+	return r.ds.GetRawPrice(symbol, date)
+}
+
+// A dividendTransaction pays the dividend of a stock into the portfolio.
+type dividendTransaction struct {
+	date     time.Time
+	stock    *Stock
+	shares   float64
+	perShare float64
+}
+
+// A splitTransaction changes the number of shares of a stock after a split.
+type splitTransaction struct {
+	date        time.Time
+	stock       *Stock
+	deltaVolume float64
+}
+
+// payDividends applies the splits and pays the dividends of all stocks held
+// which go ex after `since` up to and including `date`. Dividends are then
+// reinvested, kept or paid out on `date` according to the dividend mode of
+// the portfolio.
+func (p *multiPortfolio) payDividends(since time.Time, date time.Time) error {
+	if p.dividends == nil {
+		return nil
+	}
+
+	total := 0.0
+	for day := since.AddDate(0, 0, 1); !day.After(date); day = day.AddDate(0, 0, 1) {
+		paid, err := p.payDividendsOn(day)
+		if err != nil {
+			return err
+		}
+		total += paid
+	}
+
+	if total <= 0 {
+		return nil
+	}
+	switch p.dividendMode {
+	case ReinvestDividends:
+		// Dividends too small to buy a share stay in cash
+		_ = p.rebalance(total, date)
+	case PayOutDividends:
+		p.payOut(total, date)
+	}
+	return nil
+}
+
+// payDividendsOn applies the splits and pays the dividends of all stocks
+// held going ex on exactly `date`. It returns the dividends paid after taxes.
+func (p *multiPortfolio) payDividendsOn(date time.Time) (float64, error) {
+	total := 0.0
+	for _, stock := range p.sortedStocks() {
+		held := p.stocks[stock]
+		if held <= 0 {
+			continue
+		}
+
+		split, err := p.dividends.GetSplit(stock.Symbol, date)
+		if err != nil {
+			return total, err
+		}
+		if split > 0 && split != 1.0 {
+			p.transact(&splitTransaction{date: date, stock: stock, deltaVolume: held * (split - 1.0)})
+			held = p.stocks[stock]
+		}
+
+		dividend, err := p.dividends.GetDividend(stock.Symbol, date)
+		if err != nil {
+			return total, err
+		}
+		if dividend > 0 {
+			if dividend, err = p.convert(dividend, stock, date); err != nil {
+				return total, err
+			}
+			// Count dividends after taxes
+			before := p.cash
+			p.transact(&dividendTransaction{date: date, stock: stock, shares: held, perShare: dividend})
+			total += p.cash - before
+		}
+	}
+	return total, nil
+}
+
+func (t *dividendTransaction) delta() float64 {
+	return t.shares * t.perShare
+}
+
+func (t *dividendTransaction) inflow() float64 {
+	return 0.0
+}
+
+func (t *dividendTransaction) txDate() time.Time {
+	return t.date
+}
+
+func (t *splitTransaction) delta() float64 {
+	return 0.0
+}
+
+func (t *splitTransaction) inflow() float64 {
+	return 0.0
+}
+
+func (t *splitTransaction) txDate() time.Time {
+	return t.date
+}
@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDividendSource struct {
+	mockPriceProvider
+}
+
+func (m *mockDividendSource) GetRawPrice(symbol string, date time.Time) (float64, error) {
+	args := m.Called(symbol, date)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *mockDividendSource) GetDividend(symbol string, date time.Time) (float64, error) {
+	args := m.Called(symbol, date)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *mockDividendSource) GetSplit(symbol string, date time.Time) (float64, error) {
+	args := m.Called(symbol, date)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+// newDividendTestPortfolio creates a portfolio holding 10 shares of a stock
+// priced 10 which pays a dividend of 2 per share on `date`.
+func newDividendTestPortfolio(t *testing.T, mode DividendMode, split float64) (p *multiPortfolio, stock *Stock, date time.Time) {
+	date = time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	stock = &Stock{Symbol: "DIST"}
+
+	ds := &mockDividendSource{}
+	ds.On("GetRawPrice", "DIST", date).Return(10.0, nil)
+	ds.On("GetDividend", "DIST", date).Return(2.0, nil)
+	ds.On("GetSplit", "DIST", date).Return(split, nil)
+
+	p = newTestPortfolio(t, &mockPriceProvider{}, 0.0, map[*Stock]float64{stock: 10}, map[*Stock]float64{stock: 1.0}, nil, WithDividends(ds, mode))
+	return p, stock, date
+}
+
+func TestPayDividends(t *testing.T) {
+	p, stock, date := newDividendTestPortfolio(t, KeepDividends, 1.0)
+	assert.Nil(t, p.payDividends(date.AddDate(0, 0, -1), date))
+	assert.Equal(t, 20.0, p.getCashBalance(), "Dividends should be kept in cash")
+	assert.Equal(t, 10.0, p.stocks[stock])
+	value, err := p.TotalValue(date)
+	assert.Nil(t, err)
+	assert.Equal(t, 120.0, value, "Portfolio should be valued at raw prices")
+	assert.Empty(t, p.cashFlows(), "Dividends are no cash flows of the investor")
+
+	p, stock, date = newDividendTestPortfolio(t, ReinvestDividends, 1.0)
+	assert.Nil(t, p.payDividends(date.AddDate(0, 0, -1), date))
+	assert.Equal(t, 0.0, p.getCashBalance())
+	assert.Equal(t, 12.0, p.stocks[stock], "Dividends should be reinvested")
+
+	p, stock, date = newDividendTestPortfolio(t, PayOutDividends, 1.0)
+	assert.Nil(t, p.payDividends(date.AddDate(0, 0, -1), date))
+	assert.Equal(t, 0.0, p.getCashBalance())
+	assert.Equal(t, 10.0, p.stocks[stock])
+	assert.Equal(t, []cashFlow{{date: date, amount: 20.0}}, p.cashFlows(),
+		"Dividends should be paid out")
+
+	// Splits are applied before the dividend is paid on the new shares
+	p, stock, date = newDividendTestPortfolio(t, KeepDividends, 2.0)
+	assert.Nil(t, p.payDividends(date.AddDate(0, 0, -1), date))
+	assert.Equal(t, 20.0, p.stocks[stock])
+	assert.Equal(t, 40.0, p.getCashBalance())
+	ledger := p.Ledger()
+	assert.Equal(t, "split", ledger[0].Type)
+	assert.Equal(t, 10.0, ledger[0].Shares)
+	assert.Equal(t, "dividend", ledger[1].Type)
+	assert.Equal(t, 40.0, ledger[1].Amount)
+}
+
+func TestParseDividendMode(t *testing.T) {
+	for name, ref := range map[string]DividendMode{
+		"":         AdjustedPrices,
+		"adjusted": AdjustedPrices,
+		"reinvest": ReinvestDividends,
+		"cash":     KeepDividends,
+		"payout":   PayOutDividends,
+	} {
+		mode, err := ParseDividendMode(name)
+		assert.Nil(t, err)
+		assert.Equal(t, ref, mode)
+	}
+
+	_, err := ParseDividendMode("unknown")
+	assert.NotNil(t, err)
+
+	_, err = DividendOption(&mockPriceProvider{}, KeepDividends)
+	assert.NotNil(t, err, "Expected an error for a price source without dividends")
+	_, err = DividendOption(&mockPriceProvider{}, AdjustedPrices)
+	assert.Nil(t, err)
+}
+
+func TestSimulateStratOnRefDividends(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2010, 3, 31, 12, 0, 0, 0, time.UTC)
+	exDate := time.Date(2010, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	ds := &mockDividendSource{}
+	ds.On("GetRawPrice", "DIST", mock.Anything).Return(100.0, nil)
+	ds.On("GetDividend", "DIST", exDate).Return(5.0, nil)
+	ds.On("GetDividend", "DIST", mock.Anything).Return(0.0, nil)
+	ds.On("GetSplit", "DIST", mock.Anything).Return(1.0, nil)
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "DIST", Weight: 1.0}},
+		PriceS:    ds,
+		Income:    IncomeSpec{Monthly: 1000.0},
+		Options:   []PortfolioOption{WithDividends(ds, PayOutDividends)},
+	}
+	res, err := SimulateStratOnRef(cfg, NewMonthlyStrategy(start))
+	assert.Nil(t, err)
+	// 10 shares are held on the ex-date
+	assert.Equal(t, 50.0, res.Dividends)
+	assert.Equal(t, 50.0, res.Withdrawn)
+	assert.Equal(t, 3000.0, res.FinalValue)
+}
@@ -0,0 +1,190 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sgasse/finca/ohlc"
+)
+
+// A Bar holds the prices of a symbol on one trading day, see `ohlc.Bar`.
+type Bar = ohlc.Bar
+
+// A BarSource provides the daily bars of symbols to execute trades at other
+// prices than the close.
+type BarSource interface {
+	// GetBar returns the bar of a symbol on exactly the given date and tells
+	// if the symbol traded on this date.
+	GetBar(string, time.Time) (Bar, bool, error)
+}
+
+// An ExecutionModel determines at which price the trades of a strategy are
+// executed.
+type ExecutionModel int
+
+const (
+	// SameDayClose trades at the close of the day of the decision.
+	SameDayClose ExecutionModel = iota
+	// NextDayOpen trades at the open of the next trading day, as an order
+	// placed after the close would be.
+	NextDayOpen
+	// MidHighLow trades at the mid of the high and the low of the day of the
+	// decision, a proxy of the volume-weighted average price.
+	MidHighLow
+)
+
+var executionModelNames = map[ExecutionModel]string{
+	SameDayClose: "close",
+	NextDayOpen:  "nextOpen",
+	MidHighLow:   "midRange",
+}
+
+func (model ExecutionModel) String() string {
+	return executionModelNames[model]
+}
+
+// ParseExecutionModel returns the model named `close`, `nextOpen` or
+// `midRange`. An empty name is the model `SameDayClose`.
+func ParseExecutionModel(name string) (ExecutionModel, error) {
+	if name == "" {
+		return SameDayClose, nil
+	}
+	for model, modelName := range executionModelNames {
+		if modelName == name {
+			return model, nil
+		}
+	}
+	return SameDayClose, fmt.Errorf("Unknown execution price %q", name)
+}
+
+// Days searched for the next trading day after the day of the decision
+const maxDaysToNextOpen = 7
+
+// An ExecutionSpec describes the execution of trades in a scenario.
+type ExecutionSpec struct {
+	// One of `close` (default), `nextOpen` or `midRange`
+	Price string `json:"price,omitempty" yaml:"price,omitempty"`
+	// Slippage including half the bid-ask spread in basis points, paid on
+	// top of the price of purchases and deducted from the price of sales
+	Slippage float64 `json:"slippage,omitempty" yaml:"slippage,omitempty"`
+}
+
+// Model returns the execution model of the spec after checking the
+// slippage.
+func (spec ExecutionSpec) Model() (ExecutionModel, error) {
+	if spec.Slippage < 0.0 || spec.Slippage >= 10000.0 {
+		return SameDayClose, fmt.Errorf("Slippage of %v basis points not between 0 and 10000", spec.Slippage)
+	}
+	return ParseExecutionModel(spec.Price)
+}
+
+// String describes the spec like `nextOpen with 5 bps slippage`.
+func (spec ExecutionSpec) String() string {
+	model, _ := ParseExecutionModel(spec.Price)
+	if spec.Slippage == 0.0 {
+		return model.String()
+	}
+	return fmt.Sprintf("%s with %v bps slippage", model, spec.Slippage)
+}
+
+// WithExecution executes all trades of the portfolio at the prices of
+// `model` from the bars of `bars`, worsened by `slippage` basis points. If no
+// bar is found, e.g. for the next open on the last day of the data, trades
+// are executed at the close. By default, trades are executed at the close
+// without slippage.
+func WithExecution(bars BarSource, model ExecutionModel, slippage float64) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.bars = bars
+		p.execution = model
+		p.slippage = slippage
+	}
+}
+
+// ExecutionOption returns the portfolio option executing trades as described
+// by `spec` with bars of `priceS`, which has to be a `BarSource` unless
+// trades are executed at the close.
+func ExecutionOption(priceS PriceSource, spec ExecutionSpec) (PortfolioOption, error) {
+	model, err := spec.Model()
+	if err != nil {
+		return nil, err
+	}
+	if model == SameDayClose {
+		return WithExecution(nil, model, spec.Slippage), nil
+	}
+	bars, ok := priceS.(BarSource)
+	if !ok {
+		return nil, errors.New("The price source provides no open, high and low prices")
+	}
+	return WithExecution(bars, model, spec.Slippage), nil
+}
+
+// An execution is the price of a share in the currency of the portfolio at
+// which a stock is traded. Trades are recorded on the date of the decision
+// and executed at the price of `executed`, which can be a later day.
+type execution struct {
+	price    float64
+	date     time.Time
+	executed time.Time
+}
+
+// executions returns the executions of trades of all `stocks` decided on
+// `date`, purchases if `buy` is true and sales otherwise.
+func (p *multiPortfolio) executions(stocks []*Stock, date time.Time, buy bool) (map[*Stock]execution, error) {
+	execs := make(map[*Stock]execution)
+	for _, stock := range stocks {
+		exec, err := p.execute(stock, date)
+		if err != nil {
+			return nil, err
+		}
+
+		if buy {
+			exec.price *= 1.0 + p.slippage/10000.0
+		} else {
+			exec.price *= 1.0 - p.slippage/10000.0
+		}
+		execs[stock] = exec
+	}
+	return execs, nil
+}
+
+// execute returns the execution of a trade of `stock` decided on `date`
+// according to the execution model, without slippage.
+func (p *multiPortfolio) execute(stock *Stock, date time.Time) (execution, error) {
+	switch p.execution {
+	case NextDayOpen:
+		day, ok := p.nextTradingDay(date, maxDaysToNextOpen)
+		if !ok {
+			break
+		}
+		bar, ok, err := p.bars.GetBar(stock.Symbol, day)
+		if err != nil {
+			return execution{}, err
+		}
+		if ok && bar.Open > 0 {
+			price, err := p.convert(p.adjust(bar, bar.Open), stock, day)
+			return execution{price, date, day}, err
+		}
+	case MidHighLow:
+		bar, ok, err := p.bars.GetBar(stock.Symbol, date)
+		if err != nil {
+			return execution{}, err
+		}
+		if ok && bar.High > 0 && bar.Low > 0 {
+			price, err := p.convert(p.adjust(bar, (bar.High+bar.Low)/2), stock, date)
+			return execution{price, date, date}, err
+		}
+	}
+
+	price, err := p.price(stock, date)
+	return execution{price, date, date}, err
+}
+
+// adjust adjusts `price` of `bar` for dividends like the close if the
+// portfolio is traded at adjusted prices.
+func (p *multiPortfolio) adjust(bar Bar, price float64) float64 {
+	if p.dividends != nil || bar.Close <= 0 {
+		return price
+	}
+	return price * bar.AdjustedClose / bar.Close
+}
@@ -0,0 +1,105 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockBarSource struct {
+	mockPriceProvider
+}
+
+func (m *mockBarSource) GetBar(symbol string, date time.Time) (Bar, bool, error) {
+	args := m.Called(symbol, date)
+	return args.Get(0).(Bar), args.Bool(1), args.Error(2)
+}
+
+func TestParseExecutionModel(t *testing.T) {
+	model, err := ParseExecutionModel("")
+	assert.Nil(t, err)
+	assert.Equal(t, SameDayClose, model)
+
+	model, err = ParseExecutionModel("nextOpen")
+	assert.Nil(t, err)
+	assert.Equal(t, NextDayOpen, model)
+	assert.Equal(t, "nextOpen", model.String())
+
+	_, err = ParseExecutionModel("vwap")
+	assert.NotNil(t, err)
+
+	_, err = ExecutionSpec{Slippage: -1.0}.Model()
+	assert.NotNil(t, err, "Expected an error for a negative slippage")
+
+	_, err = ExecutionOption(&mockPriceProvider{}, ExecutionSpec{Price: "midRange"})
+	assert.NotNil(t, err, "Expected an error for a source without bars")
+	_, err = ExecutionOption(&mockPriceProvider{}, ExecutionSpec{Slippage: 5.0})
+	assert.Nil(t, err, "Slippage at the close should need no bars")
+}
+
+func TestExecution(t *testing.T) {
+	// Friday and the following Monday and Tuesday
+	friday := time.Date(2021, 8, 13, 12, 0, 0, 0, time.UTC)
+	monday := time.Date(2021, 8, 16, 12, 0, 0, 0, time.UTC)
+	tuesday := time.Date(2021, 8, 17, 12, 0, 0, 0, time.UTC)
+
+	bars := &mockBarSource{}
+	bars.On("GetPrice", "TEST", mock.Anything).Return(100.0, nil)
+	// Adjusted prices are half of the raw prices
+	bars.On("GetBar", "TEST", friday).Return(Bar{Open: 190.0, High: 220.0, Low: 180.0, Close: 200.0, AdjustedClose: 100.0}, true, nil)
+	bars.On("GetBar", "TEST", monday).Return(Bar{Open: 160.0, High: 170.0, Low: 150.0, Close: 160.0, AdjustedClose: 80.0}, true, nil)
+	bars.On("GetBar", "TEST", tuesday).Return(Bar{Open: 140.0, High: 150.0, Low: 130.0, Close: 140.0, AdjustedClose: 70.0}, true, nil)
+	bars.On("GetBar", "TEST", mock.Anything).Return(Bar{}, false, nil)
+
+	for _, tc := range []struct {
+		spec     ExecutionSpec
+		executed string
+		price    float64
+	}{
+		{ExecutionSpec{}, "", 100.0},
+		{ExecutionSpec{Slippage: 10.0}, "", 100.1},
+		{ExecutionSpec{Price: "midRange"}, "", 100.0},
+		{ExecutionSpec{Price: "midRange", Slippage: 50.0}, "", 100.5},
+		{ExecutionSpec{Price: "nextOpen"}, "2021-08-16", 80.0},
+	} {
+		opt, err := ExecutionOption(bars, tc.spec)
+		assert.Nil(t, err)
+
+		stock := &Stock{Symbol: "TEST"}
+		p, err := NewMultiPortfolio(bars, 1000.0, map[*Stock]float64{stock: 0}, map[*Stock]float64{stock: 1.0}, nil, opt)
+		assert.Nil(t, err)
+		assert.Nil(t, p.rebalance(1000.0, friday))
+
+		// Trades are recorded on the day of the decision to keep the ledger
+		// in the order of the dates
+		ledger := p.Ledger()
+		assert.Equal(t, "2021-08-13", ledger[0].Date, tc.spec)
+		assert.Equal(t, tc.executed, ledger[0].Executed, tc.spec)
+		assert.InDelta(t, tc.price, ledger[0].Price, 1e-9, tc.spec)
+	}
+
+	// The next open is the one of the next trading day of the calendar
+	holiday := NewSeriesCalendar(map[string]float64{"2021-08-13": 1.0, "2021-08-17": 1.0})
+	opt, _ := ExecutionOption(bars, ExecutionSpec{Price: "nextOpen"})
+	stock := &Stock{Symbol: "TEST"}
+	p, _ := NewMultiPortfolio(bars, 1000.0, map[*Stock]float64{stock: 0}, map[*Stock]float64{stock: 1.0}, nil, opt, WithCalendar(holiday))
+	assert.Nil(t, p.rebalance(1000.0, friday))
+	assert.Equal(t, "2021-08-17", p.Ledger()[0].Executed, "Monday should be a holiday")
+	assert.InDelta(t, 70.0, p.Ledger()[0].Price, 1e-9)
+
+	// Sales are executed at the price less the slippage
+	opt, _ = ExecutionOption(bars, ExecutionSpec{Slippage: 100.0})
+	p, _ = NewMultiPortfolio(bars, 0.0, map[*Stock]float64{stock: 10}, map[*Stock]float64{stock: 1.0}, nil, opt)
+	assert.Nil(t, p.withdraw(99.0, friday))
+	assert.InDelta(t, 99.0, p.Ledger()[0].Price, 1e-9)
+
+	// Trades fall back to the close if the next open is unknown
+	opt, _ = ExecutionOption(bars, ExecutionSpec{Price: "nextOpen"})
+	p, _ = NewMultiPortfolio(bars, 1000.0, map[*Stock]float64{stock: 0}, map[*Stock]float64{stock: 1.0}, nil, opt)
+	assert.Nil(t, p.rebalance(1000.0, tuesday))
+	assert.Equal(t, "2021-08-17", p.Ledger()[0].Date)
+	assert.Empty(t, p.Ledger()[0].Executed)
+	assert.Equal(t, 100.0, p.Ledger()[0].Price)
+}
@@ -0,0 +1,203 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A FeeModel calculates the fees a broker charges for a stock transaction.
+type FeeModel interface {
+	// Fees returns the fees of buying (`buy` is true) or selling stocks worth
+	// `value`.
+	Fees(value float64, buy bool) float64
+}
+
+// FlatFee charges the same amount for every transaction.
+type FlatFee struct {
+	Amount float64
+}
+
+// PercentageFee charges `Rate` of the transaction value plus `Fixed`, but at
+// least `Min` and at most `Max` if they are not zero.
+type PercentageFee struct {
+	Rate  float64
+	Fixed float64
+	Min   float64
+	Max   float64
+}
+
+// A FeeTier holds the fees of transactions worth up to `UpTo`.
+type FeeTier struct {
+	// Upper limit of the transaction value, 0 for no limit
+	UpTo  float64 `json:"upTo,omitempty" yaml:"upTo,omitempty"`
+	Fixed float64 `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+	Rate  float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// TieredFee charges the fees of the first tier the transaction value fits
+// into. Tiers are sorted by their upper limit. Transactions above the last
+// limit are charged the fees of the last tier.
+type TieredFee struct {
+	Tiers []FeeTier
+}
+
+// SavingsPlanFee executes purchases for free as many brokers do for savings
+// plans. Sales are charged with `Sell`, or free if it is nil.
+type SavingsPlanFee struct {
+	Sell FeeModel
+}
+
+func (f FlatFee) Fees(value float64, buy bool) float64 {
+	return f.Amount
+}
+
+func (f PercentageFee) Fees(value float64, buy bool) float64 {
+	fees := f.Fixed + f.Rate*value
+	if f.Min != 0.0 && fees < f.Min {
+		fees = f.Min
+	}
+	if f.Max != 0.0 && fees > f.Max {
+		fees = f.Max
+	}
+	return fees
+}
+
+func (f TieredFee) Fees(value float64, buy bool) float64 {
+	if len(f.Tiers) == 0 {
+		return 0.0
+	}
+
+	tier := f.Tiers[len(f.Tiers)-1]
+	for _, t := range f.Tiers {
+		if t.UpTo == 0.0 || value <= t.UpTo {
+			tier = t
+			break
+		}
+	}
+	return tier.Fixed + tier.Rate*value
+}
+
+func (f SavingsPlanFee) Fees(value float64, buy bool) float64 {
+	if buy || f.Sell == nil {
+		return 0.0
+	}
+	return f.Sell.Fees(value, buy)
+}
+
+// maxBuyValue returns the largest value of stocks which can be bought with
+// `budget` including the fees of `fees`. This assumes that the sum of value
+// and fees grows with the value.
+func maxBuyValue(budget float64, fees FeeModel) float64 {
+	if budget-fees.Fees(0.0, true) <= 0 {
+		return 0.0
+	}
+	if fees.Fees(budget, true) <= 0 {
+		return budget
+	}
+
+	low, high := 0.0, budget
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if mid == low || mid == high {
+			break
+		}
+		if mid+fees.Fees(mid, true) <= budget {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// minSellValue returns the smallest value of stocks which has to be sold to
+// raise `net` after the fees of `fees`. This assumes that the proceeds grow
+// with the value.
+func minSellValue(net float64, fees FeeModel) float64 {
+	high := net + fees.Fees(net, false)
+	for high-fees.Fees(high, false) < net {
+		high *= 2
+		if math.IsInf(high, 0) {
+			return high
+		}
+	}
+
+	low := net
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if mid == low || mid == high {
+			break
+		}
+		if mid-fees.Fees(mid, false) >= net {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return high
+}
+
+// A FeeSpec describes a fee model in a scenario. Without a type, the fees are
+// `Fixed` plus `Var` of the transaction value.
+type FeeSpec struct {
+	// One of `flat`, `percentage`, `tiered` or `savingsPlan`
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Fees per transaction of the type `flat`, added to the percentage
+	// otherwise
+	Fixed float64 `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+	// Fraction of the transaction value, e.g. 0.0025 for 0.25%
+	Var float64 `json:"var,omitempty" yaml:"var,omitempty"`
+	// Minimum and maximum fees of the type `percentage`, 0 for none
+	Min   float64   `json:"min,omitempty" yaml:"min,omitempty"`
+	Max   float64   `json:"max,omitempty" yaml:"max,omitempty"`
+	Tiers []FeeTier `json:"tiers,omitempty" yaml:"tiers,omitempty"`
+	// Fees of sales of the type `savingsPlan`, sales are free without
+	Sell *FeeSpec `json:"sell,omitempty" yaml:"sell,omitempty"`
+}
+
+// Model creates the fee model described by the spec.
+func (spec FeeSpec) Model() (FeeModel, error) {
+	if spec.Fixed < 0.0 || spec.Var < 0.0 || spec.Var >= 1.0 || spec.Min < 0.0 || spec.Max < 0.0 {
+		return nil, errors.New("Fees must not be negative and below 100%")
+	}
+
+	switch spec.Type {
+	case "":
+		return PercentageFee{Rate: spec.Var, Fixed: spec.Fixed}, nil
+	case "flat":
+		return FlatFee{Amount: spec.Fixed}, nil
+	case "percentage":
+		if spec.Max != 0.0 && spec.Max < spec.Min {
+			return nil, fmt.Errorf("Maximum fees %v below minimum fees %v", spec.Max, spec.Min)
+		}
+		return PercentageFee{Rate: spec.Var, Fixed: spec.Fixed, Min: spec.Min, Max: spec.Max}, nil
+	case "tiered":
+		if len(spec.Tiers) == 0 {
+			return nil, errors.New("No fee tiers given")
+		}
+		for i, tier := range spec.Tiers {
+			if tier.Fixed < 0.0 || tier.Rate < 0.0 || tier.Rate >= 1.0 {
+				return nil, fmt.Errorf("Invalid fees of tier %d", i+1)
+			}
+			if i > 0 && spec.Tiers[i-1].UpTo == 0.0 {
+				return nil, fmt.Errorf("Tier %d follows a tier without limit", i+1)
+			}
+			if i > 0 && tier.UpTo != 0.0 && tier.UpTo <= spec.Tiers[i-1].UpTo {
+				return nil, fmt.Errorf("Tiers are not sorted by their limits")
+			}
+		}
+		return TieredFee{Tiers: spec.Tiers}, nil
+	case "savingsPlan":
+		model := SavingsPlanFee{}
+		if spec.Sell != nil {
+			sell, err := spec.Sell.Model()
+			if err != nil {
+				return nil, fmt.Errorf("sell: %v", err)
+			}
+			model.Sell = sell
+		}
+		return model, nil
+	}
+	return nil, fmt.Errorf("Unknown fee type %q", spec.Type)
+}
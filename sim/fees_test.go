@@ -0,0 +1,81 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeModels(t *testing.T) {
+	assert.Equal(t, 10.0, FlatFee{Amount: 10.0}.Fees(5000.0, true))
+
+	capped := PercentageFee{Rate: 0.0025, Min: 4.9, Max: 69.9}
+	assert.Equal(t, 4.9, capped.Fees(1000.0, true), "Minimum not applied")
+	assert.InDelta(t, 12.5, capped.Fees(5000.0, false), 1e-9)
+	assert.Equal(t, 69.9, capped.Fees(50000.0, true), "Maximum not applied")
+
+	tiered := TieredFee{Tiers: []FeeTier{
+		{UpTo: 1000.0, Fixed: 4.9},
+		{UpTo: 10000.0, Fixed: 9.9},
+		{Fixed: 4.9, Rate: 0.001},
+	}}
+	assert.Equal(t, 4.9, tiered.Fees(1000.0, true))
+	assert.Equal(t, 9.9, tiered.Fees(1000.01, true))
+	assert.InDelta(t, 24.9, tiered.Fees(20000.0, true), 1e-9)
+
+	limited := TieredFee{Tiers: []FeeTier{{UpTo: 1000.0, Fixed: 4.9}, {UpTo: 5000.0, Fixed: 9.9}}}
+	assert.Equal(t, 9.9, limited.Fees(8000.0, true), "Last tier should apply above all limits")
+
+	plan := SavingsPlanFee{Sell: FlatFee{Amount: 9.9}}
+	assert.Equal(t, 0.0, plan.Fees(1000.0, true), "Savings plan purchases should be free")
+	assert.Equal(t, 9.9, plan.Fees(1000.0, false))
+	assert.Equal(t, 0.0, SavingsPlanFee{}.Fees(1000.0, false))
+}
+
+func TestMaxBuyValueMinSellValue(t *testing.T) {
+	capped := PercentageFee{Rate: 0.0025, Min: 4.9, Max: 69.9}
+
+	// Minimum fees
+	assert.InDelta(t, 995.1, maxBuyValue(1000.0, capped), 1e-6)
+	assert.InDelta(t, 1004.9, minSellValue(1000.0, capped), 1e-6)
+	// Percentage
+	assert.InDelta(t, 10000.0, maxBuyValue(10025.0, capped), 1e-6)
+	// Maximum fees
+	assert.InDelta(t, 49930.1, maxBuyValue(50000.0, capped), 1e-6)
+	assert.InDelta(t, 50069.9, minSellValue(50000.0, capped), 1e-6)
+
+	assert.Equal(t, 0.0, maxBuyValue(4.0, capped), "Budget below the fees should buy nothing")
+	assert.Equal(t, 1000.0, maxBuyValue(1000.0, SavingsPlanFee{}))
+}
+
+func TestFeeSpecModel(t *testing.T) {
+	model, err := FeeSpec{Fixed: 5.0, Var: 0.01}.Model()
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Fixed: 5.0, Rate: 0.01}, model)
+
+	model, err = FeeSpec{Type: "percentage", Var: 0.0025, Min: 4.9, Max: 69.9}.Model()
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Rate: 0.0025, Min: 4.9, Max: 69.9}, model)
+
+	model, err = FeeSpec{Type: "savingsPlan", Sell: &FeeSpec{Type: "flat", Fixed: 9.9}}.Model()
+	assert.Nil(t, err)
+	assert.Equal(t, SavingsPlanFee{Sell: FlatFee{Amount: 9.9}}, model)
+
+	model, err = FeeSpec{Type: "tiered", Tiers: []FeeTier{{UpTo: 1000.0, Fixed: 4.9}, {Fixed: 9.9}}}.Model()
+	assert.Nil(t, err)
+	assert.Equal(t, 9.9, model.Fees(2000.0, true))
+
+	for _, spec := range []FeeSpec{
+		{Type: "unknown"},
+		{Fixed: -1.0},
+		{Var: 1.0},
+		{Type: "percentage", Var: 0.01, Min: 10.0, Max: 5.0},
+		{Type: "tiered"},
+		{Type: "tiered", Tiers: []FeeTier{{Fixed: 4.9}, {UpTo: 1000.0, Fixed: 9.9}}},
+		{Type: "tiered", Tiers: []FeeTier{{UpTo: 1000.0, Fixed: 4.9}, {UpTo: 500.0, Fixed: 9.9}}},
+		{Type: "savingsPlan", Sell: &FeeSpec{Type: "unknown"}},
+	} {
+		_, err := spec.Model()
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -1,6 +1,10 @@
 package sim
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"time"
 )
 
@@ -13,6 +17,56 @@ type MonthlyIncome struct {
 	monthlyAmount float64
 }
 
+// AnnualRaise pays a monthly income which is raised by `rate` on every
+// anniversary of the start date.
+type AnnualRaise struct {
+	MonthlyIncome
+	start      time.Time
+	baseAmount float64
+	rate       float64
+}
+
+// InflationIndexed pays a monthly income which grows every month with an
+// annual inflation of `rate`.
+type InflationIndexed struct {
+	MonthlyIncome
+	start      time.Time
+	baseAmount float64
+	rate       float64
+}
+
+// BonusIncome pays `amount` once in every month given in `months`.
+type BonusIncome struct {
+	months   map[time.Month]bool
+	amount   float64
+	lastPaid time.Time
+}
+
+// LumpSums pays one-off amounts on the first day on or after their dates.
+type LumpSums struct {
+	payments []lumpSum
+	next     int
+}
+
+type lumpSum struct {
+	date   time.Time
+	amount float64
+}
+
+// PausedIncome pays the income of `inner` except during pauses.
+type PausedIncome struct {
+	inner  Income
+	pauses []pause
+}
+
+type pause struct {
+	from time.Time
+	to   time.Time
+}
+
+// CombinedIncome pays the sum of several incomes.
+type CombinedIncome []Income
+
 func (mi *MonthlyIncome) tick(date time.Time) float64 {
 	if mi.lastPaid.Month() != date.Month() {
 		// Pay out
@@ -22,9 +76,217 @@ func (mi *MonthlyIncome) tick(date time.Time) float64 {
 	return 0.0
 }
 
+func (ar *AnnualRaise) tick(date time.Time) float64 {
+	years := date.Year() - ar.start.Year()
+	if ar.start.AddDate(years, 0, 0).After(date) {
+		years--
+	}
+	if years < 0 {
+		years = 0
+	}
+	ar.monthlyAmount = ar.baseAmount * math.Pow(1+ar.rate, float64(years))
+	return ar.MonthlyIncome.tick(date)
+}
+
+func (ii *InflationIndexed) tick(date time.Time) float64 {
+	months := 12*(date.Year()-ii.start.Year()) + int(date.Month()-ii.start.Month())
+	if months < 0 {
+		months = 0
+	}
+	ii.monthlyAmount = ii.baseAmount * math.Pow(1+ii.rate, float64(months)/12)
+	return ii.MonthlyIncome.tick(date)
+}
+
+func (bi *BonusIncome) tick(date time.Time) float64 {
+	if bi.months[date.Month()] && !investedThisMonth(date, bi.lastPaid) {
+		bi.lastPaid = date
+		return bi.amount
+	}
+	return 0.0
+}
+
+func (ls *LumpSums) tick(date time.Time) float64 {
+	amount := 0.0
+	for ls.next < len(ls.payments) && !ls.payments[ls.next].date.After(date) {
+		amount += ls.payments[ls.next].amount
+		ls.next++
+	}
+	return amount
+}
+
+func (pi *PausedIncome) tick(date time.Time) float64 {
+	// Always tick the inner income to keep its state up to date
+	amount := pi.inner.tick(date)
+	for _, p := range pi.pauses {
+		if !date.Before(p.from) && date.Before(p.to) {
+			return 0.0
+		}
+	}
+	return amount
+}
+
+func (ci CombinedIncome) tick(date time.Time) float64 {
+	amount := 0.0
+	for _, inc := range ci {
+		amount += inc.tick(date)
+	}
+	return amount
+}
+
 func NewIncome(startDate time.Time, amount float64) Income {
 	return &MonthlyIncome{
 		lastPaid:      startDate.Add(-31 * 24 * time.Hour),
 		monthlyAmount: amount,
 	}
 }
+
+// NewAnnualRaiseIncome creates a monthly income starting at `amount` which
+// is raised by `rate` (e.g. 0.03 for 3%) every year after `startDate`.
+func NewAnnualRaiseIncome(startDate time.Time, amount float64, rate float64) Income {
+	return &AnnualRaise{
+		MonthlyIncome: *NewIncome(startDate, amount).(*MonthlyIncome),
+		start:         startDate,
+		baseAmount:    amount,
+		rate:          rate,
+	}
+}
+
+// NewInflationIndexedIncome creates a monthly income starting at `amount`
+// which grows monthly with an annual inflation of `rate` after `startDate`.
+func NewInflationIndexedIncome(startDate time.Time, amount float64, rate float64) Income {
+	return &InflationIndexed{
+		MonthlyIncome: *NewIncome(startDate, amount).(*MonthlyIncome),
+		start:         startDate,
+		baseAmount:    amount,
+		rate:          rate,
+	}
+}
+
+// NewBonusIncome creates an income paying `amount` once in each of `months`.
+func NewBonusIncome(months []time.Month, amount float64) Income {
+	bonusMonths := make(map[time.Month]bool)
+	for _, m := range months {
+		bonusMonths[m] = true
+	}
+	return &BonusIncome{months: bonusMonths, amount: amount}
+}
+
+// NewLumpSums creates an income paying one-off amounts on the given dates.
+// Payments dated before the start of a simulation are paid on its first day.
+func NewLumpSums(payments map[time.Time]float64) Income {
+	ls := &LumpSums{}
+	for date, amount := range payments {
+		ls.payments = append(ls.payments, lumpSum{date, amount})
+	}
+	sort.Slice(ls.payments, func(i, j int) bool {
+		return ls.payments[i].date.Before(ls.payments[j].date)
+	})
+	return ls
+}
+
+// NewPausedIncome creates an income paying the income of `inner` except
+// from the first to the second date of every pause.
+func NewPausedIncome(inner Income, pauses [][2]time.Time) Income {
+	pi := &PausedIncome{inner: inner}
+	for _, p := range pauses {
+		pi.pauses = append(pi.pauses, pause{from: p[0], to: p[1]})
+	}
+	return pi
+}
+
+// An IncomeSpec describes the income paid into a portfolio. Dates are given
+// as `2006-01-02`.
+type IncomeSpec struct {
+	// Income paid on the first day of every month
+	Monthly float64 `json:"monthly" yaml:"monthly"`
+	// Yearly raise of the monthly income, e.g. 0.03 for 3%
+	Raise float64 `json:"raise,omitempty" yaml:"raise,omitempty"`
+	// Yearly inflation the monthly income is indexed to
+	Inflation float64       `json:"inflation,omitempty" yaml:"inflation,omitempty"`
+	Bonuses   []BonusSpec   `json:"bonuses,omitempty" yaml:"bonuses,omitempty"`
+	LumpSums  []LumpSumSpec `json:"lumpSums,omitempty" yaml:"lumpSums,omitempty"`
+	// Periods without monthly income and bonuses
+	Pauses []PauseSpec `json:"pauses,omitempty" yaml:"pauses,omitempty"`
+}
+
+// A BonusSpec describes an amount paid every year in a month.
+type BonusSpec struct {
+	Month  int     `json:"month" yaml:"month"`
+	Amount float64 `json:"amount" yaml:"amount"`
+}
+
+// A LumpSumSpec describes a one-off payment.
+type LumpSumSpec struct {
+	Date   string  `json:"date" yaml:"date"`
+	Amount float64 `json:"amount" yaml:"amount"`
+}
+
+// A PauseSpec describes a period from `From` until before `To` without
+// contributions.
+type PauseSpec struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// Build creates a new income from the spec starting at `startDate`. Incomes
+// keep state while they are simulated, so a new one has to be built for
+// every run.
+func (spec IncomeSpec) Build(startDate time.Time) (Income, error) {
+	if spec.Raise != 0.0 && spec.Inflation != 0.0 {
+		return nil, errors.New("Income can either have a raise or be indexed to inflation")
+	}
+
+	var regular CombinedIncome
+	switch {
+	case spec.Raise != 0.0:
+		regular = append(regular, NewAnnualRaiseIncome(startDate, spec.Monthly, spec.Raise))
+	case spec.Inflation != 0.0:
+		regular = append(regular, NewInflationIndexedIncome(startDate, spec.Monthly, spec.Inflation))
+	default:
+		regular = append(regular, NewIncome(startDate, spec.Monthly))
+	}
+
+	for _, b := range spec.Bonuses {
+		if b.Month < 1 || b.Month > 12 {
+			return nil, fmt.Errorf("Invalid bonus month %d", b.Month)
+		}
+		regular = append(regular, NewBonusIncome([]time.Month{time.Month(b.Month)}, b.Amount))
+	}
+
+	var inc Income = regular
+	if len(spec.Pauses) > 0 {
+		var pauses [][2]time.Time
+		for _, p := range spec.Pauses {
+			from, err := parseSpecDate(p.From)
+			if err != nil {
+				return nil, err
+			}
+			to, err := parseSpecDate(p.To)
+			if err != nil {
+				return nil, err
+			}
+			pauses = append(pauses, [2]time.Time{from, to})
+		}
+		inc = NewPausedIncome(inc, pauses)
+	}
+
+	if len(spec.LumpSums) > 0 {
+		payments := make(map[time.Time]float64)
+		for _, ls := range spec.LumpSums {
+			date, err := parseSpecDate(ls.Date)
+			if err != nil {
+				return nil, err
+			}
+			payments[date] += ls.Amount
+		}
+		inc = CombinedIncome{inc, NewLumpSums(payments)}
+	}
+
+	return inc, nil
+}
+
+// parseSpecDate parses a date given as `2006-01-02` in a spec to the start
+// of this day in UTC.
+func parseSpecDate(date string) (time.Time, error) {
+	return time.Parse("2006-01-02", date)
+}
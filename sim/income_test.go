@@ -4,6 +4,8 @@ import (
 	"math"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNewIncome(t *testing.T) {
@@ -45,3 +47,109 @@ func TestMonthlyIncomeTick(t *testing.T) {
 	}
 
 }
+
+// payouts ticks `inc` every day from `start` until before `end` and returns
+// the payouts by month as `2006-01`.
+func payouts(inc Income, start time.Time, end time.Time) map[string]float64 {
+	paid := make(map[string]float64)
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		if pay := inc.tick(day); pay != 0.0 {
+			paid[day.Format("2006-01")] += pay
+		}
+	}
+	return paid
+}
+
+func TestAnnualRaiseIncome(t *testing.T) {
+	start := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	paid := payouts(NewAnnualRaiseIncome(start, 1000.0, 0.1), start, time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.InDelta(t, 1000.0, paid["2020-03"], 1e-7)
+	assert.InDelta(t, 1000.0, paid["2021-02"], 1e-7)
+	assert.InDelta(t, 1100.0, paid["2021-03"], 1e-7)
+	assert.InDelta(t, 1210.0, paid["2022-03"], 1e-7)
+	assert.Len(t, paid, 25)
+}
+
+func TestInflationIndexedIncome(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	paid := payouts(NewInflationIndexedIncome(start, 1000.0, 0.02), start, time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.InDelta(t, 1000.0, paid["2020-01"], 1e-7)
+	assert.InDelta(t, 1000.0*math.Pow(1.02, 0.5), paid["2020-07"], 1e-7)
+	assert.InDelta(t, 1020.0, paid["2021-01"], 1e-7)
+}
+
+func TestBonusIncome(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	paid := payouts(NewBonusIncome([]time.Month{time.June, time.December}, 500.0), start, time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, map[string]float64{
+		"2020-06": 500.0,
+		"2020-12": 500.0,
+		"2021-06": 500.0,
+		"2021-12": 500.0,
+	}, paid)
+}
+
+func TestLumpSums(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	inc := NewLumpSums(map[time.Time]float64{
+		time.Date(2020, 5, 3, 0, 0, 0, 0, time.UTC):  2000.0,
+		time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC):  100.0,
+		time.Date(2020, 5, 30, 0, 0, 0, 0, time.UTC): 50.0,
+	})
+
+	assert.Equal(t, 100.0, inc.tick(start))
+	assert.Equal(t, 0.0, inc.tick(time.Date(2020, 5, 2, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2000.0, inc.tick(time.Date(2020, 5, 3, 12, 0, 0, 0, time.UTC)))
+	// Payments are made on the first tick after their date
+	assert.Equal(t, 50.0, inc.tick(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 0.0, inc.tick(time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestPausedIncome(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	inc := NewPausedIncome(NewIncome(start, 1000.0), [][2]time.Time{
+		{time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	paid := payouts(inc, start, time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, map[string]float64{
+		"2020-01": 1000.0,
+		"2020-02": 1000.0,
+		"2020-05": 1000.0,
+		"2020-06": 1000.0,
+	}, paid)
+}
+
+func TestIncomeSpecBuild(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	spec := IncomeSpec{
+		Monthly:  1000.0,
+		Raise:    0.1,
+		Bonuses:  []BonusSpec{{Month: 12, Amount: 500.0}},
+		LumpSums: []LumpSumSpec{{Date: "2020-08-15", Amount: 10000.0}},
+		Pauses:   []PauseSpec{{From: "2020-06-01", To: "2021-01-01"}},
+	}
+
+	inc, err := spec.Build(start)
+	assert.Nil(t, err)
+	paid := payouts(inc, start, time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.InDelta(t, 1000.0, paid["2020-05"], 1e-7)
+	assert.InDelta(t, 10000.0, paid["2020-08"], 1e-7)
+	// The bonus is paused as well
+	assert.InDelta(t, 0.0, paid["2020-12"], 1e-7)
+	assert.InDelta(t, 1100.0, paid["2021-01"], 1e-7)
+
+	for _, spec := range []IncomeSpec{
+		{Monthly: 1000.0, Raise: 0.1, Inflation: 0.02},
+		{Bonuses: []BonusSpec{{Month: 13, Amount: 1.0}}},
+		{LumpSums: []LumpSumSpec{{Date: "2020/01/01", Amount: 1.0}}},
+		{Pauses: []PauseSpec{{From: "2020-01-01"}}},
+	} {
+		_, err := spec.Build(start)
+		assert.NotNil(t, err, "%+v", spec)
+	}
+}
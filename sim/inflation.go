@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A CPISeries holds the consumer price index over time to convert nominal
+// values into real values.
+type CPISeries struct {
+	dates []time.Time
+	index []float64
+}
+
+// NewCPISeries creates a series from the index values `points` on their
+// dates. The index is assumed constant until the next date.
+func NewCPISeries(points map[time.Time]float64) (*CPISeries, error) {
+	if len(points) == 0 {
+		return nil, errors.New("No CPI values given")
+	}
+
+	c := &CPISeries{}
+	for date := range points {
+		c.dates = append(c.dates, date)
+	}
+	sort.Slice(c.dates, func(i, j int) bool {
+		return c.dates[i].Before(c.dates[j])
+	})
+	for _, date := range c.dates {
+		if points[date] <= 0 {
+			return nil, fmt.Errorf("CPI of %s must be positive", date.Format("2006-01-02"))
+		}
+		c.index = append(c.index, points[date])
+	}
+	return c, nil
+}
+
+// At returns the latest index value on or before `date`.
+func (c *CPISeries) At(date time.Time) (float64, error) {
+	i := sort.Search(len(c.dates), func(i int) bool {
+		return c.dates[i].After(date)
+	})
+	if i == 0 {
+		return 0.0, fmt.Errorf("No CPI before %s", date.Format("2006-01-02"))
+	}
+	return c.index[i-1], nil
+}
+
+// Deflate converts `value` on `date` into money of `base`.
+func (c *CPISeries) Deflate(value float64, date time.Time, base time.Time) (float64, error) {
+	baseIndex, err := c.At(base)
+	if err != nil {
+		return 0.0, err
+	}
+	index, err := c.At(date)
+	if err != nil {
+		return 0.0, err
+	}
+	return value * baseIndex / index, nil
+}
+
+// realIRR calculates the internal rate of return in percent of `flows` in
+// money of `base`, assuming that `value` is paid out on `date`.
+func (c *CPISeries) realIRR(flows []cashFlow, date time.Time, value float64, base time.Time) (float64, error) {
+	realFlows, err := c.deflateFlows(flows, base)
+	if err != nil {
+		return 0.0, err
+	}
+
+	realValue, err := c.Deflate(value, date, base)
+	if err != nil {
+		return 0.0, err
+	}
+	return irrPercent(realFlows, date, realValue)
+}
+
+// deflateFlows converts every cash flow of `flows` into money of `base`.
+func (c *CPISeries) deflateFlows(flows []cashFlow, base time.Time) ([]cashFlow, error) {
+	realFlows := make([]cashFlow, 0, len(flows))
+	for _, cf := range flows {
+		amount, err := c.Deflate(cf.amount, cf.date, base)
+		if err != nil {
+			return nil, err
+		}
+		realFlows = append(realFlows, cashFlow{date: cf.date, amount: amount})
+	}
+	return realFlows, nil
+}
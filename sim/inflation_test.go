@@ -0,0 +1,67 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCPISeries(t *testing.T) {
+	jan := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC)
+	cpi, err := NewCPISeries(map[time.Time]float64{feb: 110.0, jan: 100.0})
+	assert.Nil(t, err)
+
+	index, err := cpi.At(jan.AddDate(0, 0, 20))
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, index, "Index should be constant until the next date")
+
+	_, err = cpi.At(jan.AddDate(0, 0, -1))
+	assert.NotNil(t, err, "Expected an error before the first date")
+
+	real, err := cpi.Deflate(110.0, feb.AddDate(1, 0, 0), jan)
+	assert.Nil(t, err)
+	assert.InDelta(t, 100.0, real, 1e-9, "The latest index should be used after the last date")
+
+	_, err = NewCPISeries(nil)
+	assert.NotNil(t, err)
+	_, err = NewCPISeries(map[time.Time]float64{jan: 0.0})
+	assert.NotNil(t, err)
+}
+
+func TestSimulateStratOnRefReal(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2011, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "CASH", mock.Anything).Return(100.0, nil)
+
+	// Prices double within a year
+	cpi, err := NewCPISeries(map[time.Time]float64{
+		start.AddDate(0, 0, -1): 100.0,
+		end.AddDate(0, 0, -1):   200.0,
+	})
+	assert.Nil(t, err)
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "CASH", Weight: 1.0}},
+		PriceS:    priceP,
+		Income:    IncomeSpec{LumpSums: []LumpSumSpec{{Date: "2010-01-01", Amount: 1000.0}}},
+		CPI:       cpi,
+	}
+	res, err := SimulateStratOnRef(cfg, &NoInvest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, res.IRR)
+	assert.Equal(t, 1000.0, res.RealValues[0])
+	assert.Equal(t, 500.0, res.RealFinalValue, "Cash should lose half of its value")
+	assert.Equal(t, -50.0, res.RealIRR)
+	assert.Equal(t, 0.0, res.Metrics.TWR)
+	if assert.NotNil(t, res.RealMetrics) {
+		assert.Equal(t, -50.0, res.RealMetrics.TWR, "Real metrics should lose with inflation")
+		assert.Equal(t, -50.0, res.RealMetrics.MaxDrawdown)
+	}
+}
@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// A cashFlow is an amount of money exchanged between the investor and the
+// portfolio. Money paid into the portfolio is negative, money paid out to the
+// investor is positive.
+type cashFlow struct {
+	date   time.Time
+	amount float64
+}
+
+const (
+	irrMaxIter = 100
+	// Relative precision of the net present value at the IRR
+	irrPrec = 1e-9
+)
+
+// Candidate rates which are scanned for a sign change of the net present value
+// if Newton's method does not converge.
+var irrBrackets = []float64{
+	-0.9999, -0.999, -0.99, -0.95, -0.9, -0.75, -0.5, -0.25, -0.1, 0.0,
+	0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 100.0, 1000.0,
+}
+
+// xirr calculates the annual internal rate of return of irregularly timed
+// cash flows. It uses Newton's method and falls back to bisection in a bracket
+// with a sign change of the net present value if Newton's method does not
+// converge. The rate is returned as fraction, e.g. 0.05 for 5%.
+func xirr(flows []cashFlow) (float64, error) {
+	if len(flows) == 0 {
+		return 0.0, errors.New("No cash flows to calculate the IRR of")
+	}
+
+	sorted := make([]cashFlow, len(flows))
+	copy(sorted, flows)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].date.Before(sorted[j].date)
+	})
+
+	hasIn, hasOut := false, false
+	scale := 0.0
+	for _, cf := range sorted {
+		if cf.amount < 0 {
+			hasIn = true
+		} else if cf.amount > 0 {
+			hasOut = true
+		}
+		scale = math.Max(scale, math.Abs(cf.amount))
+	}
+
+	if !hasIn {
+		return 0.0, errors.New("IRR undefined: no money was paid in")
+	}
+	if !hasOut {
+		// Everything was lost
+		return -1.0, nil
+	}
+
+	npv := buildNPVFunc(sorted)
+	prec := irrPrec * scale
+
+	if rate, ok := newtonIRR(npv, 0.1, prec); ok {
+		return rate, nil
+	}
+
+	low, high, err := bracketIRR(npv)
+	if err != nil {
+		return 0.0, err
+	}
+	return bisectIRR(npv, low, high, prec)
+}
+
+// buildNPVFunc returns a function calculating the net present value of `flows`
+// and its derivative for a given annual rate. The flows are discounted to the
+// date of the first flow.
+func buildNPVFunc(flows []cashFlow) func(float64) (float64, float64) {
+	yearHours := 365.0 * 24.0
+	t0 := flows[0].date
+	return func(rate float64) (val float64, deriv float64) {
+		for _, cf := range flows {
+			years := cf.date.Sub(t0).Hours() / yearHours
+			disc := math.Pow(1+rate, -years)
+			val += cf.amount * disc
+			deriv -= years * cf.amount * disc / (1 + rate)
+		}
+		return
+	}
+}
+
+func newtonIRR(npv func(float64) (float64, float64), guess float64, prec float64) (float64, bool) {
+	rate := guess
+	for i := 0; i < irrMaxIter; i++ {
+		val, deriv := npv(rate)
+		if math.Abs(val) <= prec {
+			return rate, true
+		}
+		if deriv == 0.0 {
+			return 0.0, false
+		}
+
+		rate -= val / deriv
+		if rate <= -1.0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return 0.0, false
+		}
+	}
+	return 0.0, false
+}
+
+func bracketIRR(npv func(float64) (float64, float64)) (low float64, high float64, err error) {
+	prevVal, _ := npv(irrBrackets[0])
+	for i := 1; i < len(irrBrackets); i++ {
+		val, _ := npv(irrBrackets[i])
+		if math.Signbit(val) != math.Signbit(prevVal) {
+			return irrBrackets[i-1], irrBrackets[i], nil
+		}
+		prevVal = val
+	}
+	err = fmt.Errorf("IRR not found between %.2f%% and %.0f%%",
+		irrBrackets[0]*100, irrBrackets[len(irrBrackets)-1]*100)
+	return
+}
+
+func bisectIRR(npv func(float64) (float64, float64), low float64, high float64, prec float64) (float64, error) {
+	lowVal, _ := npv(low)
+	for i := 0; i < 10*irrMaxIter; i++ {
+		mid := low + (high-low)/2
+		val, _ := npv(mid)
+		if math.Abs(val) <= prec || (high-low)/2 < 1e-12 {
+			return mid, nil
+		}
+
+		if math.Signbit(val) == math.Signbit(lowVal) {
+			low, lowVal = mid, val
+		} else {
+			high = mid
+		}
+	}
+	return 0.0, errors.New("IRR did not converge")
+}
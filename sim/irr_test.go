@@ -0,0 +1,111 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 12, 0, 0, 0, time.UTC)
+}
+
+func TestXIRR(t *testing.T) {
+	// Simple positive and negative returns over one year
+	irr, err := xirr([]cashFlow{
+		{day(2019, 1, 1), -1000.0},
+		{day(2020, 1, 1), 1100.0},
+	})
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.1, irr, 1e-6)
+
+	irr, err = xirr([]cashFlow{
+		{day(2019, 1, 1), -1000.0},
+		{day(2020, 1, 1), 800.0},
+	})
+	assert.Nil(t, err)
+	assert.InDelta(t, -0.2, irr, 1e-6)
+
+	// Reference example of the XIRR spreadsheet function
+	irr, err = xirr([]cashFlow{
+		{day(2008, 1, 1), -10000.0},
+		{day(2008, 3, 1), 2750.0},
+		{day(2008, 10, 30), 4250.0},
+		{day(2009, 2, 15), 3250.0},
+		{day(2009, 4, 1), 2750.0},
+	})
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.373362535, irr, 1e-6)
+
+	// Unsorted flows with a withdrawal in between
+	irr, err = xirr([]cashFlow{
+		{day(2021, 1, 1), 600.0},
+		{day(2019, 1, 1), -1000.0},
+		{day(2020, 1, 1), 500.0},
+	})
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.0639, irr, 1e-4)
+
+	// Very large loss which Newton's method overshoots
+	irr, err = xirr([]cashFlow{
+		{day(2019, 1, 1), -1000.0},
+		{day(2020, 1, 1), 5.0},
+	})
+	assert.Nil(t, err)
+	assert.InDelta(t, -0.995, irr, 1e-6)
+
+	// Total loss
+	irr, err = xirr([]cashFlow{
+		{day(2020, 1, 1), -1000.0},
+		{day(2021, 1, 1), 0.0},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, -1.0, irr)
+}
+
+func TestXIRRErrors(t *testing.T) {
+	_, err := xirr(nil)
+	assert.NotNil(t, err, "Expected an error without cash flows")
+
+	_, err = xirr([]cashFlow{
+		{day(2020, 1, 1), 1000.0},
+		{day(2021, 1, 1), 1000.0},
+	})
+	assert.NotNil(t, err, "Expected an error without money paid in")
+}
+
+func TestCalcIRR(t *testing.T) {
+	start := day(2019, 1, 1)
+	end := day(2020, 1, 1)
+	sTest := &Stock{Symbol: "TEST.DE"}
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", start).Return(100.0, nil)
+	priceP.On("GetPrice", "TEST.DE", mock.Anything).Return(90.0, nil)
+
+	p, err := NewMultiPortfolio(priceP, 0.0, map[*Stock]float64{sTest: 0},
+		map[*Stock]float64{sTest: 1.0}, nil)
+	assert.Nil(t, err)
+
+	p.transact(&incomeTransaction{date: start, amount: 1000.0})
+	assert.Nil(t, p.rebalance(p.getCashBalance(), start))
+
+	irr, err := p.CalcIRR(end)
+	assert.Nil(t, err)
+	assert.Equal(t, -10.0, irr, "Losing strategies should have a negative IRR")
+}
+
+func TestIRRPercent(t *testing.T) {
+	irr, err := irrPercent([]cashFlow{{day(2019, 1, 1), -1000.0}}, day(2020, 1, 1), 1100.0)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, irr)
+
+	// A tiny loss is rounded to zero without a sign
+	irr, err = irrPercent([]cashFlow{{day(2019, 1, 1), -1000.0}}, day(2020, 1, 1), 999.99)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, irr)
+	assert.False(t, math.Signbit(irr), "IRR should not be a negative zero")
+}
@@ -0,0 +1,91 @@
+package sim
+
+// A LedgerEntry describes a single transaction of a portfolio.
+type LedgerEntry struct {
+	// Date of the transaction in the format `2006-01-02`
+	Date string `json:"date"`
+	// Date of the price of a trade if it was executed after `Date`, e.g. at
+	// the next open
+	Executed string `json:"executed,omitempty"`
+	// Kind of the transaction, e.g. `income` or `buy`
+	Type   string `json:"type"`
+	Symbol string `json:"symbol,omitempty"`
+	// Shares traded, held on a dividend or added by a split
+	Shares float64 `json:"shares,omitempty"`
+	// Price of a share or dividend per share
+	Price float64 `json:"price,omitempty"`
+	Fees  float64 `json:"fees,omitempty"`
+	// Change of the cash balance of the portfolio
+	Amount float64 `json:"amount"`
+	// Cash balance of the portfolio after the transaction
+	Cash float64 `json:"cash"`
+}
+
+func (t *incomeTransaction) entry() LedgerEntry {
+	return LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   "income",
+		Amount: t.delta(),
+	}
+}
+
+func (t *withdrawalTransaction) entry() LedgerEntry {
+	return LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   "withdrawal",
+		Amount: t.delta(),
+	}
+}
+
+func (t *dividendTransaction) entry() LedgerEntry {
+	return LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   "dividend",
+		Symbol: t.stock.Symbol,
+		Shares: t.shares,
+		Price:  t.perShare,
+		Amount: t.delta(),
+	}
+}
+
+func (t *splitTransaction) entry() LedgerEntry {
+	return LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   "split",
+		Symbol: t.stock.Symbol,
+		Shares: t.deltaVolume,
+		Amount: t.delta(),
+	}
+}
+
+func (t *taxTransaction) entry() LedgerEntry {
+	entry := LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   "tax",
+		Amount: t.delta(),
+	}
+	if t.stock != nil {
+		entry.Symbol = t.stock.Symbol
+	}
+	return entry
+}
+
+func (t *stockTransaction) entry() LedgerEntry {
+	trType := "buy"
+	if t.deltaVolume < 0 {
+		trType = "sell"
+	}
+	entry := LedgerEntry{
+		Date:   t.date.Format("2006-01-02"),
+		Type:   trType,
+		Symbol: t.stock.Symbol,
+		Shares: t.deltaVolume,
+		Price:  t.price,
+		Fees:   t.fees,
+		Amount: t.delta(),
+	}
+	if !t.executed.IsZero() && dayKey(t.executed) != dayKey(t.date) {
+		entry.Executed = t.executed.Format("2006-01-02")
+	}
+	return entry
+}
@@ -0,0 +1,151 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+// Annual risk-free rate used for the Sharpe and Sortino ratios
+var riskFreeRate = 0.0
+
+// Metrics describe the performance and risk of a simulated portfolio
+// independent of the amount and timing of the money paid into it. All rates
+// are given in percent.
+type Metrics struct {
+	// Time-weighted return over the whole simulation
+	TWR float64 `json:"twr"`
+	// Compound annual growth rate of the time-weighted return
+	CAGR float64 `json:"cagr"`
+	// Annualized standard deviation of the periodic returns
+	Volatility float64 `json:"volatility"`
+	Sharpe     float64 `json:"sharpe"`
+	Sortino    float64 `json:"sortino"`
+	// Largest relative decline of the time-weighted return from a previous
+	// peak, negative or zero
+	MaxDrawdown float64 `json:"maxDrawdown"`
+	// Days from the bottom of the maximum drawdown until the previous peak
+	// was reached again, -1 if it was not recovered
+	RecoveryDays int `json:"recoveryDays"`
+}
+
+// calcMetrics calculates the metrics of a portfolio with the total values
+// `values` on `dates` and the cash flows `flows` of the investor. Returns are
+// calculated between consecutive dates, with the cash flows in between
+// assumed at the end of each period.
+func calcMetrics(dates []time.Time, values []float64, flows []cashFlow, riskFree float64) (m Metrics) {
+	returns, index := periodReturns(dates, values, flows)
+	if len(returns) == 0 {
+		return
+	}
+
+	twr := index[len(index)-1] - 1.0
+	years := dates[len(dates)-1].Sub(dates[0]).Hours() / (365.0 * 24.0)
+	periodsPerYear := float64(len(returns)) / years
+
+	m.TWR = RoundTo(2, twr*100)
+	m.CAGR = RoundTo(2, (math.Pow(1+twr, 1/years)-1)*100)
+
+	mean, std := meanStd(returns)
+	annMean := mean * periodsPerYear
+	vol := std * math.Sqrt(periodsPerYear)
+	m.Volatility = RoundTo(2, vol*100)
+	if vol > 0 {
+		m.Sharpe = RoundTo(2, (annMean-riskFree)/vol)
+	}
+
+	downside := 0.0
+	for _, r := range returns {
+		excess := r - riskFree/periodsPerYear
+		if excess < 0 {
+			downside += excess * excess
+		}
+	}
+	downside = math.Sqrt(downside/float64(len(returns))) * math.Sqrt(periodsPerYear)
+	if downside > 0 {
+		m.Sortino = RoundTo(2, (annMean-riskFree)/downside)
+	}
+
+	maxDD, recovery := maxDrawdown(dates, index)
+	m.MaxDrawdown = RoundTo(2, maxDD*100)
+	m.RecoveryDays = recovery
+	return
+}
+
+// periodReturns calculates the return of every period between two
+// consecutive dates corrected by the cash flows in between. It also returns
+// the cumulative growth of one unit of money for every date.
+func periodReturns(dates []time.Time, values []float64, flows []cashFlow) (returns []float64, index []float64) {
+	if len(dates) < 2 {
+		return
+	}
+
+	index = append(index, 1.0)
+	for i := 1; i < len(dates); i++ {
+		inflow := 0.0
+		for _, cf := range flows {
+			if cf.date.After(dates[i-1]) && !cf.date.After(dates[i]) {
+				inflow -= cf.amount
+			}
+		}
+
+		r := 0.0
+		if values[i-1] > 0 {
+			r = (values[i]-inflow)/values[i-1] - 1.0
+		}
+		returns = append(returns, r)
+		index = append(index, index[i-1]*(1+r))
+	}
+	return
+}
+
+// maxDrawdown determines the largest relative decline of `index` from a
+// previous peak and the days it took to recover to this peak from the bottom.
+func maxDrawdown(dates []time.Time, index []float64) (maxDD float64, recoveryDays int) {
+	peak, peakIdx := index[0], 0
+	ddPeak, ddBottom := 0, 0
+	for i, val := range index {
+		if val >= peak {
+			peak, peakIdx = val, i
+			continue
+		}
+		if dd := val/peak - 1.0; dd < maxDD {
+			maxDD = dd
+			ddPeak, ddBottom = peakIdx, i
+		}
+	}
+
+	recoveryDays = -1
+	if maxDD == 0.0 {
+		recoveryDays = 0
+		return
+	}
+	for i := ddBottom + 1; i < len(index); i++ {
+		if index[i] >= index[ddPeak] {
+			recoveryDays = int(math.Round(dates[i].Sub(dates[ddBottom]).Hours() / 24))
+			break
+		}
+	}
+	return
+}
+
+func meanStd(values []float64) (mean float64, std float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	if len(values) < 2 {
+		return
+	}
+	for _, v := range values {
+		std += (v - mean) * (v - mean)
+	}
+	std = math.Sqrt(std / float64(len(values)-1))
+	return
+}
+
+// RoundTo rounds `number` to `digits` decimal places.
+func RoundTo(digits float64, number float64) float64 {
+	factor := math.Pow(10, digits)
+	return math.Round(number*factor) / factor
+}
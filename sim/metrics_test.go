@@ -0,0 +1,76 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodReturns(t *testing.T) {
+	dates := []time.Time{day(2020, 1, 1), day(2020, 2, 1), day(2020, 3, 1)}
+	// Value doubles in the first month, then 1000.0 are paid in and the value
+	// stays constant
+	values := []float64{1000.0, 2000.0, 3000.0}
+	flows := []cashFlow{
+		{day(2020, 1, 1), -1000.0},
+		{day(2020, 3, 1), -1000.0},
+	}
+
+	returns, index := periodReturns(dates, values, flows)
+	assert.InDeltaSlice(t, []float64{1.0, 0.0}, returns, 1e-9)
+	assert.InDeltaSlice(t, []float64{1.0, 2.0, 2.0}, index, 1e-9)
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	dates := []time.Time{
+		day(2020, 1, 1), day(2020, 2, 1), day(2020, 3, 1), day(2020, 4, 1), day(2020, 5, 1),
+	}
+
+	maxDD, recovery := maxDrawdown(dates, []float64{1.0, 1.2, 0.6, 0.9, 1.2})
+	assert.InDelta(t, -0.5, maxDD, 1e-9)
+	assert.Equal(t, 61, recovery, "Recovery should span March to May")
+
+	maxDD, recovery = maxDrawdown(dates, []float64{1.0, 1.2, 0.6, 0.9, 1.1})
+	assert.InDelta(t, -0.5, maxDD, 1e-9)
+	assert.Equal(t, -1, recovery, "Drawdown should not be recovered")
+
+	maxDD, recovery = maxDrawdown(dates, []float64{1.0, 1.1, 1.2, 1.3, 1.4})
+	assert.Equal(t, 0.0, maxDD)
+	assert.Equal(t, 0, recovery)
+}
+
+func TestCalcMetrics(t *testing.T) {
+	var dates []time.Time
+	var values []float64
+	var flows []cashFlow
+
+	// Constant growth of 1% per month with monthly payments of 100.0
+	value := 0.0
+	for m := 0; m <= 24; m++ {
+		date := day(2018, time.Month(m+1), 1)
+		value = value*1.01 + 100.0
+		dates = append(dates, date)
+		values = append(values, value)
+		flows = append(flows, cashFlow{date, -100.0})
+	}
+
+	m := calcMetrics(dates, values, flows, 0.0)
+	assert.InDelta(t, (math.Pow(1.01, 12)-1)*100, m.CAGR, 0.1)
+	assert.InDelta(t, (math.Pow(1.01, 24)-1)*100, m.TWR, 0.01)
+	assert.Equal(t, 0.0, m.Volatility, "Constant returns should have no volatility")
+	assert.Equal(t, 0.0, m.MaxDrawdown)
+	assert.Equal(t, 0, m.RecoveryDays)
+
+	// Cash only portfolio without any return
+	m = calcMetrics(dates[:3], []float64{100.0, 200.0, 300.0}, flows[:3], 0.0)
+	assert.Equal(t, Metrics{}, m)
+
+	// A drop of the value is a drawdown and counts as downside
+	m = calcMetrics(dates[:3], []float64{100.0, 50.0, 100.0}, flows[:1], 0.0)
+	assert.Equal(t, -50.0, m.MaxDrawdown)
+	assert.Equal(t, 28, m.RecoveryDays, "Recovery should span February 2018")
+	assert.True(t, m.Volatility > 0.0)
+	assert.True(t, m.Sortino != 0.0)
+}
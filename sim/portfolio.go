@@ -3,32 +3,42 @@ package sim
 import (
 	"errors"
 	"fmt"
-	"log"
 	"math"
+	"sort"
 	"time"
-
-	"github.com/sgasse/finca/av"
 )
 
-var fixedFeePerStock = 56.0
-
 type Portfolio interface {
 	SetStart(time.Time)
-	TotalValue(time.Time) float64
-	CalcIRR(time.Time) float64
+	TotalValue(time.Time) (float64, error)
+	CalcIRR(time.Time) (float64, error)
+	Ledger() []LedgerEntry
 	getCashBalance() float64
+	cashFlows() []cashFlow
 	transact(transaction)
 	rebalance(float64, time.Time) error
+	withdraw(float64, time.Time) error
+	payDividends(time.Time, time.Time) error
+	depletedOn() time.Time
+	afterTaxValue(time.Time) (float64, error)
 }
 
 type Stock struct {
 	Symbol string
 	WKN    string
 	ISIN   string
+	// Currency of the prices of the stock, empty for the currency of the
+	// investor
+	Currency string
 }
 
 type transaction interface {
+	// Change of the cash balance of the portfolio
 	delta() float64
+	// Money paid into the portfolio from outside, negative if paid out
+	inflow() float64
+	txDate() time.Time
+	entry() LedgerEntry
 }
 
 type incomeTransaction struct {
@@ -36,24 +46,61 @@ type incomeTransaction struct {
 	amount float64
 }
 
+// A withdrawalTransaction pays money out of the portfolio to the investor.
+type withdrawalTransaction struct {
+	date   time.Time
+	amount float64
+}
+
 type stockTransaction struct {
-	date        time.Time
+	// Date of the decision to trade
+	date time.Time
+	// Date of the price of the trade if it differs from `date`, e.g. of the
+	// next open
+	executed    time.Time
 	stock       *Stock
-	deltaVolume int64
+	deltaVolume float64
 	price       float64
+	fees        float64
 }
 
 type multiPortfolio struct {
-	startDate    time.Time
+	priceS    PriceSource
+	startDate time.Time
+	// Cash the portfolio was created with and the current cash balance
+	initialCash  float64
 	cash         float64
-	stocks       map[*Stock]int64
+	stocks       map[*Stock]float64
 	transactions []transaction
 	goalRatios   map[*Stock]float64
-	fixedFees    float64
-	varFees      float64
+	fees         FeeModel
+	// Date of the first withdrawal which could not be paid in full
+	depleted      time.Time
+	rebalanceMode RebalanceMode
+	toleranceBand float64
+	// Decimal places of shares which can be traded, 0 for whole shares
+	shareDecimals int
+	// Source of dividends if they are not included in the prices
+	dividends    DividendSource
+	dividendMode DividendMode
+	// Lots and allowance if the portfolio is taxed
+	tax *taxState
+	// Currency of the investor and exchange rates to convert prices into it
+	currency string
+	fx       FXSource
+	// Prices at which trades are executed and the slippage in basis points
+	bars      BarSource
+	execution ExecutionModel
+	slippage  float64
+	// Days on which the portfolio is traded, weekdays if not given
+	calendar Calendar
 }
 
-func NewMultiPortfolio(cash float64, stocks map[*Stock]int64, goalRatios map[*Stock]float64, fixedFees float64, varFees float64) (Portfolio, error) {
+// NewMultiPortfolio creates a new portfolio holding `stocks` which is
+// rebalanced towards `goalRatios`. All prices for valuation and transactions
+// are looked up with `priceS`. Further behaviour can be configured with
+// `opts`. Transactions are free if `fees` is nil.
+func NewMultiPortfolio(priceS PriceSource, cash float64, stocks map[*Stock]float64, goalRatios map[*Stock]float64, fees FeeModel, opts ...PortfolioOption) (Portfolio, error) {
 	ratioSum := 0.0
 	for stock, ratio := range goalRatios {
 		ratioSum += ratio
@@ -73,147 +120,313 @@ func NewMultiPortfolio(cash float64, stocks map[*Stock]int64, goalRatios map[*St
 	if math.Abs(ratioSum-1.0) > 1e-6 {
 		return &multiPortfolio{}, errors.New("Goal ratios do not sum up to 1.0")
 	}
-	return &multiPortfolio{cash: cash, stocks: stocks, goalRatios: goalRatios, fixedFees: fixedFees, varFees: varFees}, nil
+	if fees == nil {
+		fees = FlatFee{}
+	}
+
+	p := &multiPortfolio{
+		priceS:      priceS,
+		initialCash: cash,
+		cash:        cash,
+		stocks:      stocks,
+		goalRatios:  goalRatios,
+		fees:        fees,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
 }
 
 func (p *multiPortfolio) SetStart(date time.Time) {
 	p.startDate = date
 }
 
-func (p *multiPortfolio) TotalValue(date time.Time) float64 {
-	totalStockValue, err := getTotalStockValue(p.stocks, date)
+// TotalValue returns the value of the cash and all stocks of the portfolio
+// on `date`. It fails if the price of a stock is missing on `date`.
+func (p *multiPortfolio) TotalValue(date time.Time) (float64, error) {
+	totalStockValue, err := p.totalStockValue(date)
 	if err != nil {
-		log.Fatal(err)
+		return 0.0, err
 	}
 
 	totalValue := p.cash + totalStockValue
 
-	return totalValue
+	return totalValue, nil
 }
 
-func (p *multiPortfolio) CalcIRR(date time.Time) float64 {
-	totalValue := p.TotalValue(date)
-	fx := buildTransactionFunc(p.transactions, totalValue, date)
-	irr := bisect(fx, 5.0, 1.0, 1e-3, 100)
+// CalcIRR calculates the money-weighted internal rate of return in percent
+// of all money paid into and out of the portfolio up to `date`, assuming that
+// the total value of the portfolio is paid out on `date`.
+func (p *multiPortfolio) CalcIRR(date time.Time) (float64, error) {
+	value, err := p.TotalValue(date)
+	if err != nil {
+		return 0.0, err
+	}
+	return irrPercent(p.cashFlows(), date, value)
+}
+
+// irrPercent calculates the internal rate of return in percent of `flows` up
+// to `date`, assuming that `value` is paid out on `date`.
+func irrPercent(cashFlows []cashFlow, date time.Time, value float64) (float64, error) {
+	var flows []cashFlow
+	for _, cf := range cashFlows {
+		if !cf.date.After(date) {
+			flows = append(flows, cf)
+		}
+	}
+	flows = append(flows, cashFlow{date: date, amount: value})
+
+	irr, err := xirr(flows)
+	if err != nil {
+		return 0.0, err
+	}
+
 	// Transform to percent
-	irr = (irr - 1.0) * 100
+	irr = irr * 100
 	// Round to two digits after the comma
 	irr = math.Round(irr*100) / 100
-	return irr
+	if irr == 0.0 {
+		// Avoid a negative zero
+		irr = 0.0
+	}
+	return irr, nil
 }
 
 func (p *multiPortfolio) getCashBalance() float64 {
 	return p.cash
 }
 
+// Ledger returns an entry for every transaction of the portfolio in the
+// order they happened, together with the cash balance after it.
+func (p *multiPortfolio) Ledger() []LedgerEntry {
+	ledger := make([]LedgerEntry, 0, len(p.transactions))
+	cash := p.initialCash
+	for _, tr := range p.transactions {
+		cash += tr.delta()
+		entry := tr.entry()
+		entry.Cash = cash
+		ledger = append(ledger, entry)
+	}
+	return ledger
+}
+
+// cashFlows returns all money paid into the portfolio (negative) or out to the
+// investor (positive).
+func (p *multiPortfolio) cashFlows() []cashFlow {
+	var flows []cashFlow
+	for _, tr := range p.transactions {
+		if inflow := tr.inflow(); inflow != 0.0 {
+			flows = append(flows, cashFlow{date: tr.txDate(), amount: -inflow})
+		}
+	}
+	return flows
+}
+
 func (p *multiPortfolio) transact(tr transaction) {
 	p.transactions = append(p.transactions, tr)
 	p.cash = p.cash + tr.delta()
-	if st, ok := tr.(*stockTransaction); ok {
+	switch st := tr.(type) {
+	case *stockTransaction:
 		p.stocks[st.stock] += st.deltaVolume
+	case *splitTransaction:
+		p.stocks[st.stock] += st.deltaVolume
+	}
+	if p.tax != nil {
+		p.applyTax(tr)
+	}
+}
+
+// withdraw pays `amount` out to the investor. Cash missing for the
+// withdrawal is raised by selling shares, more if the sales are taxed. If the
+// portfolio is not worth enough, everything left is paid out and an error is
+// returned.
+func (p *multiPortfolio) withdraw(amount float64, date time.Time) error {
+	for missing := amount - p.cash; missing > 0; missing = amount - p.cash {
+		before := p.cash
+		if err := p.sell(missing, date); err != nil {
+			return err
+		}
+		if p.cash <= before {
+			break
+		}
+	}
+
+	paid := math.Min(amount, p.cash)
+	if paid > 0 {
+		p.payOut(paid, date)
+	}
+
+	if paid < amount {
+		if p.depleted.IsZero() {
+			p.depleted = date
+		}
+		return errors.New(fmt.Sprint("Portfolio depleted, could only withdraw ",
+			RoundTo(2, paid), " of ", RoundTo(2, amount)))
 	}
+	return nil
 }
 
-func (p *multiPortfolio) rebalance(amount float64, date time.Time) error {
-	// Naiv, safe, suboptimal rebalancing
-	curTotalStockValue, err := getTotalStockValue(p.stocks, date)
+// sell raises at least `value` in cash after fees. Shares are first sold
+// according to the goal ratios. If this is not enough, e.g. because a stock
+// is not held anymore, further shares are sold of any stock left.
+func (p *multiPortfolio) sell(value float64, date time.Time) error {
+	stocks := p.sortedStocks()
+	execs, err := p.executions(stocks, date, false)
 	if err != nil {
 		return err
 	}
 
-	totalGoalValue := curTotalStockValue + amount
-	for stock, curVol := range p.stocks {
-		// No error expected. All prices have to exist for the call to
-		// `getTotalStockValue` to have succeeded before.
-		price, _ := av.GetPrice(stock.Symbol, date)
-		goalValue := p.goalRatios[stock] * totalGoalValue
-		goalShares, adjustedPrice := calcGoalSharesAdjPrice(
-			goalValue,
-			price,
-			p.fixedFees,
-			p.varFees,
-		)
-		newShares := goalShares - curVol
-
-		if newShares == 0 {
-			return errors.New("Not enough money to buy a complete share")
+	missing := value
+	for _, stock := range stocks {
+		missing -= p.sellStock(stock, p.goalRatios[stock]*value, execs[stock])
+	}
+	for _, stock := range stocks {
+		if missing <= 0 {
+			break
 		}
+		missing -= p.sellStock(stock, missing, execs[stock])
+	}
+	return nil
+}
+
+// sellStock sells as many shares of `stock` as needed to raise `value` after
+// fees and taxes but not more than are held. It returns the cash raised after
+// taxes and does not sell if the proceeds would not cover the fees.
+func (p *multiPortfolio) sellStock(stock *Stock, value float64, exec execution) float64 {
+	held := p.stocks[stock]
+	if value <= 0 || held <= 0 {
+		return 0.0
+	}
 
-		tr := &stockTransaction{
-			date:        date,
-			stock:       stock,
-			deltaVolume: newShares,
-			price:       adjustedPrice,
+	// Sell more to pay the taxes of the sale as well, which grow with the
+	// shares sold. A few steps settle within a share.
+	shares := p.ceilShares(minSellValue(value, p.fees) / exec.price)
+	for i := 0; i < 5 && shares < held; i++ {
+		proceeds := shares*exec.price - p.fees.Fees(shares*exec.price, false)
+		tax := p.saleTax(stock, shares, proceeds, exec.date)
+		if proceeds-tax >= value {
+			break
 		}
-		p.transact(tr)
+		shares = p.ceilShares(minSellValue(value+tax, p.fees) / exec.price)
+	}
+	if shares > held {
+		shares = held
 	}
 
-	return nil
+	tr := &stockTransaction{
+		date:        exec.date,
+		executed:    exec.executed,
+		stock:       stock,
+		deltaVolume: -shares,
+		price:       exec.price,
+		fees:        p.fees.Fees(shares*exec.price, false),
+	}
+	if tr.delta() <= 0 {
+		return 0.0
+	}
+	before := p.cash
+	p.transact(tr)
+	return p.cash - before
+}
+
+// sortedStocks returns the stocks of the portfolio sorted by their symbols
+// to make transactions independent of the order of the map.
+func (p *multiPortfolio) sortedStocks() []*Stock {
+	stocks := make([]*Stock, 0, len(p.stocks))
+	for stock := range p.stocks {
+		stocks = append(stocks, stock)
+	}
+	sort.Slice(stocks, func(i, j int) bool {
+		return stocks[i].Symbol < stocks[j].Symbol
+	})
+	return stocks
+}
+
+func (p *multiPortfolio) depletedOn() time.Time {
+	return p.depleted
 }
 
 func (t *incomeTransaction) delta() float64 {
 	return t.amount
 }
 
+func (t *incomeTransaction) inflow() float64 {
+	return t.amount
+}
+
+func (t *incomeTransaction) txDate() time.Time {
+	return t.date
+}
+
+func (t *withdrawalTransaction) delta() float64 {
+	return -t.amount
+}
+
+func (t *withdrawalTransaction) inflow() float64 {
+	return -t.amount
+}
+
+func (t *withdrawalTransaction) txDate() time.Time {
+	return t.date
+}
+
 func (t *stockTransaction) delta() float64 {
-	return -float64(t.deltaVolume) * t.price
+	return -t.deltaVolume*t.price - t.fees
+}
+
+func (t *stockTransaction) inflow() float64 {
+	return 0.0
 }
 
-func getTotalStockValue(stocks map[*Stock]int64, date time.Time) (float64, error) {
+func (t *stockTransaction) txDate() time.Time {
+	return t.date
+}
+
+func (p *multiPortfolio) totalStockValue(date time.Time) (float64, error) {
 	totalStockValue := 0.0
-	for stock, vol := range stocks {
-		price, err := av.GetPrice(stock.Symbol, date)
+	for stock, vol := range p.stocks {
+		price, err := p.price(stock, date)
 		if err != nil {
 			return 0.0, err
 		}
-		totalStockValue += float64(vol) * price
+		totalStockValue += vol * price
 	}
 	return totalStockValue, nil
 }
 
-func bisect(fn func(float64) float64, high float64, low float64, prec float64, maxIter int) float64 {
-	steps := 0
-	x := low + (high-low)/2
-	for diff := fn(x); math.Abs(diff) > prec && steps <= maxIter; {
-		if diff > 0 {
-			// x too large, narrow upper limit
-			high = x
-		} else {
-			// x too small, narrow lower limit
-			low = x
-		}
-		x = low + (high-low)/2
-		diff = fn(x)
-
-		steps++
+// calcGoalSharesAdjPrice calculates the shares which can be bought for
+// `goalValue` including fees, rounded down to `decimals` decimal places. It
+// also returns the price per share including fees, which is the bare price
+// if not a single share can be bought.
+func calcGoalSharesAdjPrice(goalValue float64, price float64, fees FeeModel, decimals int) (float64, float64) {
+	// newShares * price + fees(newShares * price) =!= goalValue
+	newShares := floorTo(decimals, maxBuyValue(goalValue, fees)/price)
+	if newShares <= 0 {
+		return 0, price
 	}
+	adjPrice := price + fees.Fees(newShares*price, true)/newShares
+	return newShares, adjPrice
+}
 
-	if steps == maxIter && fn(x) > prec {
-		fmt.Println("Warning: maxIter (", maxIter, ") reached without converging")
-	}
-	return x
+// floorTo rounds `number` down to `decimals` decimal places. Numbers within
+// a rounding error below the next step are rounded up.
+func floorTo(decimals int, number float64) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Floor(number*factor+1e-6) / factor
 }
 
-func buildTransactionFunc(trs []transaction, cEnd float64, date time.Time) func(float64) float64 {
-	yearHours := 365.0 * 24.0
-	fn := func(x float64) float64 {
-		res := -cEnd
-		for _, tr := range trs {
-			if incomeTr, ok := tr.(*incomeTransaction); ok {
-				dt := date.Sub(incomeTr.date).Hours() / yearHours
-				res += incomeTr.amount * math.Pow(x, dt)
-			}
-		}
+// ceilTo rounds `number` up to `decimals` decimal places. Numbers within a
+// rounding error above the previous step are rounded down.
+func ceilTo(decimals int, number float64) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Ceil(number*factor-1e-6) / factor
+}
 
-		return res
-	}
-	return fn
+func (p *multiPortfolio) floorShares(shares float64) float64 {
+	return floorTo(p.shareDecimals, shares)
 }
 
-func calcGoalSharesAdjPrice(goalValue, price, fixedFees, varFees float64) (int64, float64) {
-	// newShares * price + fixedFees + (newShares * price)*varFees =!= goalValue
-	// -> newShares = (goalValue - fixedFees) / (price * (1 + varFees))
-	newShares := math.Floor((goalValue - fixedFees) / (price * (1 + varFees)))
-	adjPrice := (1+varFees)*price + (fixedFees / newShares)
-	return int64(newShares), adjPrice
+func (p *multiPortfolio) ceilShares(shares float64) float64 {
+	return ceilTo(p.shareDecimals, shares)
 }
@@ -2,15 +2,26 @@ package sim
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// newTestPortfolio creates a portfolio holding `stocks` which is rebalanced
+// towards `ratios` and fails the test if this is not possible.
+func newTestPortfolio(t *testing.T, priceS PriceSource, cash float64, stocks map[*Stock]float64, ratios map[*Stock]float64, fees FeeModel, opts ...PortfolioOption) *multiPortfolio {
+	p, err := NewMultiPortfolio(priceS, cash, stocks, ratios, fees, opts...)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return p.(*multiPortfolio)
+}
+
 func TestMultiPortfolio(t *testing.T) {
 	sIBM := &Stock{Symbol: "IBM"}
 	sOther := &Stock{Symbol: "H411.DE"}
 
-	stocks := map[*Stock]int64{
+	stocks := map[*Stock]float64{
 		sIBM:   10,
 		sOther: 23,
 	}
@@ -21,7 +32,7 @@ func TestMultiPortfolio(t *testing.T) {
 	}
 
 	startCash := 1201.67
-	p, err := NewMultiPortfolio(startCash, stocks, goalRatios, 56.0, 0.015)
+	p, err := NewMultiPortfolio(&mockPriceProvider{}, startCash, stocks, goalRatios, PercentageFee{Fixed: 56.0, Rate: 0.015})
 	assert.Nil(t, err)
 
 	if mp, ok := p.(*multiPortfolio); ok {
@@ -32,17 +43,109 @@ func TestMultiPortfolio(t *testing.T) {
 
 }
 
+func TestMultiPortfolioPriceSource(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	sTest := &Stock{Symbol: "TEST.DE"}
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", date).Return(100.0, nil)
+
+	p, err := NewMultiPortfolio(
+		priceP,
+		1010.0,
+		map[*Stock]float64{sTest: 0},
+		map[*Stock]float64{sTest: 1.0},
+		FlatFee{Amount: 10.0},
+	)
+	assert.Nil(t, err)
+
+	err = p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, p.(*multiPortfolio).stocks[sTest], "Number of shares wrong")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "All cash should be invested")
+	value, err := p.TotalValue(date)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1000.0, value, 1e-9, "Total value wrong")
+	priceP.AssertExpectations(t)
+}
+
 func TestCalcGoalSharesAdjPrice(t *testing.T) {
 	price := 80.0
-	refGoalShares := int64(10)
+	refGoalShares := 10.0
 	fixedFees := 6.0
 	varFees := 0.015
 
-	totalMoney := price*float64(refGoalShares)*(1+varFees) + fixedFees
-	refAdjPrice := totalMoney / float64(refGoalShares)
+	totalMoney := price*refGoalShares*(1+varFees) + fixedFees
+	refAdjPrice := totalMoney / refGoalShares
 
-	goalShares, adjPrice := calcGoalSharesAdjPrice(totalMoney, price, fixedFees, varFees)
+	goalShares, adjPrice := calcGoalSharesAdjPrice(totalMoney, price, PercentageFee{Fixed: fixedFees, Rate: varFees}, 0)
 
 	assert.Equal(t, refGoalShares, goalShares, "Number of goalShares wrong")
-	assert.Equal(t, refAdjPrice, adjPrice, "Adjusted price wrong")
+	assert.InDelta(t, refAdjPrice, adjPrice, 1e-9, "Adjusted price wrong")
+
+	// Fractional shares are rounded down to the given decimal places
+	goalShares, _ = calcGoalSharesAdjPrice(totalMoney+50.0, price, PercentageFee{Fixed: fixedFees, Rate: varFees}, 2)
+	assert.Equal(t, 10.61, goalShares, "Number of fractional goalShares wrong")
+
+	// Without enough money for a single share, the price is not adjusted
+	goalShares, adjPrice = calcGoalSharesAdjPrice(fixedFees+50.0, price, PercentageFee{Fixed: fixedFees, Rate: varFees}, 0)
+	assert.Equal(t, 0.0, goalShares, "Expected no goalShares")
+	assert.Equal(t, price, adjPrice, "Adjusted price of no shares wrong")
+}
+
+func TestMultiPortfolioWithdraw(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	sTest := &Stock{Symbol: "TEST.DE"}
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", date).Return(100.0, nil)
+
+	p, err := NewMultiPortfolio(
+		priceP,
+		50.0,
+		map[*Stock]float64{sTest: 10},
+		map[*Stock]float64{sTest: 1.0},
+		FlatFee{Amount: 10.0},
+	)
+	assert.Nil(t, err)
+
+	// Sell three shares for 290 after fees to withdraw 300
+	err = p.withdraw(300.0, date)
+	assert.Nil(t, err)
+	assert.Equal(t, 7.0, p.(*multiPortfolio).stocks[sTest], "Number of shares wrong")
+	assert.InDelta(t, 40.0, p.getCashBalance(), 1e-9, "Cash balance wrong")
+	assert.True(t, p.depletedOn().IsZero(), "Portfolio should not be depleted")
+
+	// Sales are recorded at the price of a share with the fees apart
+	sale := p.Ledger()[0]
+	assert.Equal(t, "sell", sale.Type)
+	assert.Equal(t, 100.0, sale.Price)
+	assert.Equal(t, 10.0, sale.Fees)
+	assert.InDelta(t, 290.0, sale.Amount, 1e-9)
+
+	// Sell everything and pay out what is left
+	err = p.withdraw(1000.0, date)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0.0, p.(*multiPortfolio).stocks[sTest], "All shares should be sold")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "All cash should be paid out")
+	assert.Equal(t, date, p.depletedOn(), "Depletion date wrong")
+
+	withdrawn := 0.0
+	for _, entry := range p.Ledger() {
+		if entry.Type == "withdrawal" {
+			withdrawn -= entry.Amount
+		}
+	}
+	assert.InDelta(t, 1030.0, withdrawn, 1e-9, "Withdrawn amount wrong")
+
+	// Cash after every sale and withdrawal, starting from 50
+	var cash []float64
+	for _, entry := range p.Ledger() {
+		cash = append(cash, entry.Cash)
+	}
+	assert.InDeltaSlice(t, []float64{340.0, 40.0, 730.0, 0.0}, cash, 1e-9, "Cash after transactions wrong")
+
+	flows := p.cashFlows()
+	assert.Len(t, flows, 2)
+	assert.InDelta(t, 730.0, flows[1].amount, 1e-9, "Withdrawals are positive cash flows")
 }
@@ -0,0 +1,281 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A RebalanceMode determines how a portfolio is rebalanced towards its goal
+// ratios when money is invested.
+type RebalanceMode int
+
+const (
+	// BuyOnly invests new money into underweight stocks and never sells.
+	BuyOnly RebalanceMode = iota
+	// ToleranceBand rebalances fully when the weight of a stock drifted from
+	// its goal ratio by more than the tolerance band and buys only otherwise.
+	ToleranceBand
+	// FullRebalance sells overweight stocks and buys underweight ones on
+	// every rebalance.
+	FullRebalance
+)
+
+// Tolerance band used if none is given, in absolute weight
+var defaultToleranceBand = 0.05
+
+var rebalanceModeNames = map[RebalanceMode]string{
+	BuyOnly:       "buyOnly",
+	ToleranceBand: "band",
+	FullRebalance: "full",
+}
+
+func (mode RebalanceMode) String() string {
+	return rebalanceModeNames[mode]
+}
+
+// ParseRebalanceMode returns the mode named `buyOnly`, `band` or `full`.
+func ParseRebalanceMode(name string) (RebalanceMode, error) {
+	for mode, modeName := range rebalanceModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return BuyOnly, fmt.Errorf("Unknown rebalance mode %q", name)
+}
+
+// A PortfolioOption configures optional behaviour of a portfolio created
+// with `NewMultiPortfolio`.
+type PortfolioOption func(*multiPortfolio)
+
+// WithRebalanceMode sets the mode the portfolio is rebalanced with. The
+// default is `BuyOnly`.
+func WithRebalanceMode(mode RebalanceMode) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.rebalanceMode = mode
+	}
+}
+
+// Maximum decimal places of fractional shares
+const maxShareDecimals = 8
+
+// WithFractionalShares allows to trade fractions of shares with `decimals`
+// decimal places, e.g. 4 to trade 0.0001 shares. By default, only whole
+// shares are traded.
+func WithFractionalShares(decimals int) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.shareDecimals = decimals
+	}
+}
+
+// WithToleranceBand rebalances the portfolio in the mode `ToleranceBand`
+// with a band of `band` in absolute weight, e.g. 0.05 for a drift of five
+// percentage points.
+func WithToleranceBand(band float64) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.rebalanceMode = ToleranceBand
+		p.toleranceBand = band
+	}
+}
+
+// A RebalanceSpec describes how portfolios are rebalanced in a scenario.
+type RebalanceSpec struct {
+	// One of `buyOnly` (default), `band` or `full`
+	Mode string `json:"mode" yaml:"mode"`
+	// Tolerance of the mode `band` in absolute weight, defaults to 0.05
+	Band float64 `json:"band,omitempty" yaml:"band,omitempty"`
+}
+
+// Option returns the portfolio option described by the spec.
+func (spec RebalanceSpec) Option() (PortfolioOption, error) {
+	mode := BuyOnly
+	if spec.Mode != "" {
+		var err error
+		if mode, err = ParseRebalanceMode(spec.Mode); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Band < 0.0 || spec.Band >= 1.0 {
+		return nil, fmt.Errorf("Band %v not between 0 and 1", spec.Band)
+	}
+	if mode == ToleranceBand {
+		band := spec.Band
+		if band == 0.0 {
+			band = defaultToleranceBand
+		}
+		return WithToleranceBand(band), nil
+	}
+	return WithRebalanceMode(mode), nil
+}
+
+// rebalance invests `amount` towards the goal ratios according to the
+// rebalance mode. Trades which cannot buy the smallest tradable fraction of a
+// share or whose fees would exceed the traded value are skipped. An error is
+// only returned if no trade was made at all.
+func (p *multiPortfolio) rebalance(amount float64, date time.Time) error {
+	stocks := p.sortedStocks()
+	values := make(map[*Stock]float64)
+	for _, stock := range stocks {
+		price, err := p.price(stock, date)
+		if err != nil {
+			return err
+		}
+		values[stock] = p.stocks[stock] * price
+	}
+
+	traded := false
+	if p.needsFullRebalance(stocks, values) {
+		execs, err := p.executions(stocks, date, false)
+		if err != nil {
+			return err
+		}
+		raised := p.sellOverweight(stocks, execs, values, amount)
+		traded = raised > 0
+		amount += raised
+	}
+
+	execs, err := p.executions(stocks, date, true)
+	if err != nil {
+		return err
+	}
+	if p.buyUnderweight(stocks, execs, values, amount) {
+		traded = true
+	}
+
+	if !traded {
+		return errors.New("Not enough money to buy a complete share")
+	}
+	return nil
+}
+
+// needsFullRebalance tells if overweight stocks should be sold. This is
+// always the case in the mode `FullRebalance` and in the mode
+// `ToleranceBand` if a stock drifted out of the band.
+func (p *multiPortfolio) needsFullRebalance(stocks []*Stock, values map[*Stock]float64) bool {
+	switch p.rebalanceMode {
+	case FullRebalance:
+		return true
+	case ToleranceBand:
+		total := 0.0
+		for _, stock := range stocks {
+			total += values[stock]
+		}
+		if total <= 0 {
+			return false
+		}
+		for _, stock := range stocks {
+			if math.Abs(values[stock]/total-p.goalRatios[stock]) > p.toleranceBand {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sellOverweight sells stocks worth more than their goal ratio of the total
+// value including `amount` down to their goal ratio at the prices of
+// `execs`. It returns the cash raised and updates `values`.
+func (p *multiPortfolio) sellOverweight(stocks []*Stock, execs map[*Stock]execution, values map[*Stock]float64, amount float64) (raised float64) {
+	total := amount
+	for _, stock := range stocks {
+		total += values[stock]
+	}
+
+	for _, stock := range stocks {
+		excess := values[stock] - p.goalRatios[stock]*total
+		exec := execs[stock]
+		shares := p.floorShares(excess / exec.price)
+		if shares <= 0 {
+			continue
+		}
+
+		tr := &stockTransaction{
+			date:        exec.date,
+			executed:    exec.executed,
+			stock:       stock,
+			deltaVolume: -shares,
+			price:       exec.price,
+			fees:        p.fees.Fees(shares*exec.price, false),
+		}
+		if tr.fees >= shares*tr.price {
+			// Not worth the fees
+			continue
+		}
+		before := p.cash
+		p.transact(tr)
+		raised += p.cash - before
+		values[stock] -= shares * tr.price
+	}
+	return
+}
+
+// buyUnderweight splits `amount` among the stocks below their goal ratio of
+// the total value including `amount`, proportionally to how much they are
+// missing. If a stock cannot be bought economically with its share, it is
+// left out and `amount` is split among the remaining stocks. Stocks are bought
+// at the prices of `execs`. It tells if any stock was bought.
+func (p *multiPortfolio) buyUnderweight(stocks []*Stock, execs map[*Stock]execution, values map[*Stock]float64, amount float64) bool {
+	if amount <= 0 {
+		return false
+	}
+
+	total := amount
+	for _, stock := range stocks {
+		total += values[stock]
+	}
+
+	deficits := make(map[*Stock]float64)
+	for _, stock := range stocks {
+		if deficit := p.goalRatios[stock]*total - values[stock]; deficit > 0 {
+			deficits[stock] = deficit
+		}
+	}
+
+	for len(deficits) > 0 {
+		deficitSum := 0.0
+		for _, deficit := range deficits {
+			deficitSum += deficit
+		}
+
+		var trs []*stockTransaction
+		var skip *Stock
+		for _, stock := range stocks {
+			deficit, ok := deficits[stock]
+			if !ok {
+				continue
+			}
+
+			exec := execs[stock]
+			shares, _ := calcGoalSharesAdjPrice(amount*deficit/deficitSum, exec.price, p.fees, p.shareDecimals)
+			tr := &stockTransaction{
+				date:        exec.date,
+				executed:    exec.executed,
+				stock:       stock,
+				deltaVolume: shares,
+				price:       exec.price,
+				fees:        p.fees.Fees(shares*exec.price, true),
+			}
+			if shares <= 0 || tr.fees >= shares*tr.price {
+				// Leave out the smallest uneconomic position
+				if skip == nil || deficit < deficits[skip] {
+					skip = stock
+				}
+				continue
+			}
+			trs = append(trs, tr)
+		}
+
+		if skip != nil {
+			delete(deficits, skip)
+			continue
+		}
+
+		for _, tr := range trs {
+			p.transact(tr)
+		}
+		return len(trs) > 0
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRebalanceTestPortfolio creates a portfolio of the stocks A priced 100
+// and B priced 50 holding `volA` and `volB` shares.
+func newRebalanceTestPortfolio(t *testing.T, cash float64, volA float64, volB float64, ratioA float64, fixedFees float64, opts ...PortfolioOption) (p *multiPortfolio, sA *Stock, sB *Stock, date time.Time) {
+	date = time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	sA = &Stock{Symbol: "A"}
+	sB = &Stock{Symbol: "B"}
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "A", date).Return(100.0, nil)
+	priceP.On("GetPrice", "B", date).Return(50.0, nil)
+
+	p = newTestPortfolio(t, priceP, cash,
+		map[*Stock]float64{sA: volA, sB: volB},
+		map[*Stock]float64{sA: ratioA, sB: 1.0 - ratioA},
+		FlatFee{Amount: fixedFees}, opts...)
+	return p, sA, sB, date
+}
+
+func TestRebalanceBuyOnly(t *testing.T) {
+	p, sA, sB, date := newRebalanceTestPortfolio(t, 1000.0, 20, 0, 0.5, 0.0)
+
+	// Only the underweight stock is bought, nothing is sold
+	err := p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 20.0, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 20.0, p.stocks[sB], "Number of shares of B wrong")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "All cash should be invested")
+}
+
+func TestRebalanceSkipsUneconomicTrades(t *testing.T) {
+	p, sA, sB, date := newRebalanceTestPortfolio(t, 1000.0, 0, 0, 0.95, 20.0)
+
+	// The share of B does not cover the fees and goes to A instead
+	err := p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 9.0, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 0.0, p.stocks[sB], "Number of shares of B wrong")
+	assert.InDelta(t, 80.0, p.getCashBalance(), 1e-9, "Cash balance wrong")
+
+	p, _, _, date = newRebalanceTestPortfolio(t, 40.0, 0, 0, 0.5, 0.0)
+	err = p.rebalance(p.getCashBalance(), date)
+	assert.NotNil(t, err, "Expected an error if nothing can be bought")
+	assert.Empty(t, p.Ledger())
+}
+
+func TestRebalanceFull(t *testing.T) {
+	p, sA, sB, date := newRebalanceTestPortfolio(t, 0.0, 20, 0, 0.5, 0.0, WithRebalanceMode(FullRebalance))
+
+	err := p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 20.0, p.stocks[sB], "Number of shares of B wrong")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "All cash should be invested")
+}
+
+func TestRebalanceToleranceBand(t *testing.T) {
+	// A drift of about one percentage point is within the band
+	p, sA, sB, date := newRebalanceTestPortfolio(t, 100.0, 10, 19, 0.5, 0.0, WithToleranceBand(0.05))
+	err := p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 21.0, p.stocks[sB], "Number of shares of B wrong")
+
+	// A drift of 25 percentage points leads to a full rebalance
+	p, sA, sB, date = newRebalanceTestPortfolio(t, 0.0, 15, 10, 0.5, 0.0, WithToleranceBand(0.05))
+	err = p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 20.0, p.stocks[sB], "Number of shares of B wrong")
+}
+
+func TestRebalanceSpec(t *testing.T) {
+	for _, mode := range []RebalanceMode{BuyOnly, ToleranceBand, FullRebalance} {
+		parsed, err := ParseRebalanceMode(mode.String())
+		assert.Nil(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+
+	p := &multiPortfolio{}
+	opt, err := RebalanceSpec{Mode: "band"}.Option()
+	assert.Nil(t, err)
+	opt(p)
+	assert.Equal(t, ToleranceBand, p.rebalanceMode)
+	assert.Equal(t, defaultToleranceBand, p.toleranceBand)
+
+	opt, err = RebalanceSpec{}.Option()
+	assert.Nil(t, err)
+	opt(p)
+	assert.Equal(t, BuyOnly, p.rebalanceMode)
+
+	_, err = RebalanceSpec{Mode: "sometimes"}.Option()
+	assert.NotNil(t, err)
+	_, err = RebalanceSpec{Mode: "band", Band: 1.5}.Option()
+	assert.NotNil(t, err)
+}
+
+func TestRebalanceFractionalShares(t *testing.T) {
+	p, sA, sB, date := newRebalanceTestPortfolio(t, 1000.0, 0, 0, 0.3, 1.0, WithFractionalShares(4))
+
+	err := p.rebalance(p.getCashBalance(), date)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.99, p.stocks[sA], "Number of shares of A wrong")
+	assert.Equal(t, 13.98, p.stocks[sB], "Number of shares of B wrong")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "All cash should be invested")
+
+	// Selling rounds up to the precision as well
+	err = p.withdraw(10.0, date)
+	assert.Nil(t, err)
+	assert.InDelta(t, 2.95, p.stocks[sA], 1e-9, "Number of shares of A wrong")
+	assert.InDelta(t, 13.82, p.stocks[sB], 1e-9, "Number of shares of B wrong")
+	assert.InDelta(t, 0.0, p.getCashBalance(), 1e-9, "Cash balance wrong")
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Scenario describes a comparison of strategies on a portfolio. Scenarios
+// can be stored as JSON or YAML files. Dates are given as `2006-01-02`, empty
+// fields fall back to defaults of the application running the scenario.
+type Scenario struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Reference symbol for drawdown strategies, defaults to the first symbol
+	// of `Portfolio`
+	Symbol string `json:"symbol,omitempty" yaml:"symbol,omitempty"`
+	// Symbols held with their goal weights, defaults to only `Symbol`
+	Portfolio []Allocation   `json:"portfolio,omitempty" yaml:"portfolio,omitempty"`
+	From      string         `json:"from,omitempty" yaml:"from,omitempty"`
+	To        string         `json:"to,omitempty" yaml:"to,omitempty"`
+	Income    *IncomeSpec    `json:"income,omitempty" yaml:"income,omitempty"`
+	Fees      *FeeSpec       `json:"fees,omitempty" yaml:"fees,omitempty"`
+	Rebalance *RebalanceSpec `json:"rebalance,omitempty" yaml:"rebalance,omitempty"`
+	// Decimal places of fractional shares, 0 for whole shares
+	Fractional int `json:"fractional,omitempty" yaml:"fractional,omitempty"`
+	// Handling of dividends as named by `DividendMode`, defaults to prices
+	// adjusted for dividends
+	Dividends string   `json:"dividends,omitempty" yaml:"dividends,omitempty"`
+	Tax       *TaxSpec `json:"tax,omitempty" yaml:"tax,omitempty"`
+	// Calculate values in money of the start date with the consumer price
+	// index
+	Real bool `json:"real,omitempty" yaml:"real,omitempty"`
+	// Currency of the investor which the prices of symbols quoted in other
+	// currencies are converted into, empty to use all prices as they are
+	Currency string `json:"currency,omitempty" yaml:"currency,omitempty"`
+	// Prices at which trades are executed, at the close without slippage if
+	// not given
+	Execution *ExecutionSpec `json:"execution,omitempty" yaml:"execution,omitempty"`
+	// CSV file with the trades of a real portfolio to evaluate next to the
+	// strategies
+	Transactions string         `json:"transactions,omitempty" yaml:"transactions,omitempty"`
+	Strategies   []StrategySpec `json:"strategies" yaml:"strategies"`
+}
+
+// LoadScenario reads a scenario from a file. Files ending in `.json` are
+// parsed as JSON, all others as YAML.
+func LoadScenario(path string) (Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+
+	sc, err := ParseScenario(data, strings.ToLower(filepath.Ext(path)) == ".json")
+	if err != nil {
+		return Scenario{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	// Transactions are found next to the scenario file
+	if sc.Transactions != "" && !filepath.IsAbs(sc.Transactions) {
+		sc.Transactions = filepath.Join(filepath.Dir(path), sc.Transactions)
+	}
+	return sc, nil
+}
+
+// ParseScenario parses a scenario from JSON or YAML data. Unknown fields are
+// rejected to catch typos in parameter names.
+func ParseScenario(data []byte, isJSON bool) (sc Scenario, err error) {
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&sc)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&sc)
+	}
+	if err != nil {
+		return
+	}
+
+	err = sc.Validate()
+	return
+}
+
+// Validate checks that the scenario has strategies with unique names of
+// registered types, a valid portfolio, rebalancing, income, fees, dividends,
+// taxes, currency and execution.
+func (sc Scenario) Validate() error {
+	if len(sc.Strategies) == 0 {
+		return fmt.Errorf("No strategies given")
+	}
+
+	if len(sc.Portfolio) > 0 {
+		if err := ValidateAllocations(sc.Portfolio); err != nil {
+			return fmt.Errorf("portfolio: %v", err)
+		}
+	}
+
+	if sc.Fractional < 0 || sc.Fractional > maxShareDecimals {
+		return fmt.Errorf("fractional: %d not between 0 and %d", sc.Fractional, maxShareDecimals)
+	}
+
+	if sc.Rebalance != nil {
+		if _, err := sc.Rebalance.Option(); err != nil {
+			return fmt.Errorf("rebalance: %v", err)
+		}
+	}
+
+	if sc.Income != nil {
+		if _, err := sc.Income.Build(time.Time{}); err != nil {
+			return fmt.Errorf("income: %v", err)
+		}
+	}
+
+	if sc.Fees != nil {
+		if _, err := sc.Fees.Model(); err != nil {
+			return fmt.Errorf("fees: %v", err)
+		}
+	}
+
+	if _, err := ParseDividendMode(sc.Dividends); err != nil {
+		return fmt.Errorf("dividends: %v", err)
+	}
+
+	if sc.Tax != nil {
+		if _, err := sc.Tax.Option(); err != nil {
+			return fmt.Errorf("tax: %v", err)
+		}
+	}
+
+	if err := ValidateCurrency(sc.Currency); err != nil {
+		return fmt.Errorf("currency: %v", err)
+	}
+
+	if sc.Execution != nil {
+		if _, err := sc.Execution.Model(); err != nil {
+			return fmt.Errorf("execution: %v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, spec := range sc.Strategies {
+		name := spec.DisplayName()
+		if names[name] {
+			return fmt.Errorf("Duplicate strategy name %q", name)
+		}
+		names[name] = true
+
+		registry.RLock()
+		_, ok := registry.m[spec.Type]
+		registry.RUnlock()
+		if !ok {
+			return fmt.Errorf("%s: Unknown strategy type %q", name, spec.Type)
+		}
+
+		if spec.Fees != nil {
+			if _, err := spec.Fees.Model(); err != nil {
+				return fmt.Errorf("%s: fees: %v", name, err)
+			}
+		}
+		if _, err := ParseDividendMode(spec.Dividends); err != nil {
+			return fmt.Errorf("%s: dividends: %v", name, err)
+		}
+	}
+	return nil
+}
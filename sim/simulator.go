@@ -1,27 +1,61 @@
 package sim
 
 import (
+	"context"
 	"errors"
-	"log"
 	"math"
 	"time"
 )
 
-type priceProvider interface {
+// A PriceSource provides the price of a symbol on a given date. Every price
+// lookup of a simulation goes through one PriceSource so that simulations can
+// be run against any data source.
+type PriceSource interface {
 	GetPrice(string, time.Time) (float64, error)
 }
 
-func Simulate(start time.Time, p Portfolio, inc Income, strat Strategy) (pValues []float64, dates []string, err error) {
-	if time.Now().Sub(start) < 0 {
-		err = errors.New("Start lies in the future")
+// Simulate runs the strategy `strat` on the portfolio `p` with income from
+// `inc` on every trading day of `cal` from `start` up to and including `end`,
+// on every weekday if `cal` is nil. The value of the portfolio is evaluated
+// at the close of the last trading day of every month.
+func Simulate(start time.Time, end time.Time, p Portfolio, inc Income, strat Strategy, cal Calendar) (pValues []float64, dates []string, err error) {
+	if cal == nil {
+		cal = WeekdayCalendar{}
+	}
+	values, evalDates, err := simulate(context.Background(), start, end, p, inc, strat, cal)
+	if err != nil {
+		return
+	}
+
+	for i, value := range values {
+		pValues = append(pValues, math.Round(value))
+		dates = append(dates, evalDates[i].Format("2006/01/02"))
+	}
+	return
+}
+
+// simulate runs the simulation of `Simulate` on the trading days of `cal`
+// until `ctx` is done, which is checked on every evaluation day. Income,
+// investments and withdrawals due on other days happen on the next trading
+// day. Dividends going ex on other days are paid on the next trading day as
+// well.
+func simulate(ctx context.Context, start time.Time, end time.Time, p Portfolio, inc Income, strat Strategy, cal Calendar) (values []float64, evalDates []time.Time, err error) {
+	if end.Sub(start) < 0 {
+		err = errors.New("Start lies after the end")
 		return
 	}
 
 	p.SetStart(start)
 
-	simDay := start
-	// Simulate until reaching the current date
-	for time.Now().Sub(simDay) > 0 {
+	days := tradingDays(cal, start, end)
+	lastDay := start.AddDate(0, 0, -1)
+	for i, simDay := range days {
+		// Maybe receive dividends
+		if err = p.payDividends(lastDay, simDay); err != nil {
+			return
+		}
+		lastDay = simDay
+
 		// Maybe receive income
 		amount := inc.tick(simDay)
 		if amount != 0.0 {
@@ -31,51 +65,207 @@ func Simulate(start time.Time, p Portfolio, inc Income, strat Strategy) (pValues
 		// Maybe invest
 		strat.tick(simDay, p)
 
-		// Maybe evaluate
-		if simDay.Day() == 1 {
-			totalValue := math.Round(p.TotalValue((simDay)))
-			pValues = append(pValues, totalValue)
-			dates = append(dates, simDay.Format("2006/01/02"))
+		// Maybe evaluate at the close of the month
+		monthEnd := i+1 < len(days) && days[i+1].Month() != simDay.Month() ||
+			i+1 == len(days) && isLastTradingDayOfMonth(cal, simDay)
+		if monthEnd {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			var value float64
+			if value, err = p.TotalValue(simDay); err != nil {
+				return
+			}
+			values = append(values, value)
+			evalDates = append(evalDates, simDay)
 		}
-
-		// Increase day
-		simDay = simDay.Add(time.Duration(24 * time.Hour))
 	}
 
 	return
 }
 
-func SimulateStratOnRef(startDate time.Time, symbol string, strat Strategy, fixedFees float64, varFees float64) ([]float64, []string, float64) {
-	p := getRefPortfolio(symbol, fixedFees, varFees)
+// SimConfig describes the reference setting in which strategies are
+// simulated.
+type SimConfig struct {
+	Start     time.Time
+	End       time.Time
+	Portfolio []Allocation
+	PriceS    PriceSource
+	Income    IncomeSpec
+	// Fees of all stock transactions
+	Fees FeeModel
+	// Options of the simulated portfolio, e.g. its rebalance mode
+	Options []PortfolioOption
+	// Consumer price index to calculate real values with, optional
+	CPI *CPISeries
+	// Days on which the portfolio is traded, weekdays if not given
+	Calendar Calendar
+}
+
+// calendar returns the calendar of the config, weekdays if none is given.
+func (cfg SimConfig) calendar() Calendar {
+	if cfg.Calendar == nil {
+		return WeekdayCalendar{}
+	}
+	return cfg.Calendar
+}
 
-	inc := NewIncome(startDate, 1000.0)
+// StratResult holds the outcome of simulating a strategy.
+type StratResult struct {
+	// Dates of evaluation in the format `2006/01/02`
+	Dates []string `json:"dates"`
+	// Portfolio values on the evaluation dates
+	Values []float64 `json:"values"`
+	// Portfolio value on the end date
+	FinalValue float64 `json:"finalValue"`
+	// Total income paid into the portfolio
+	PaidIn float64 `json:"paidIn"`
+	// Total amount withdrawn from the portfolio, including dividends paid out
+	Withdrawn float64 `json:"withdrawn"`
+	// Total dividends paid into the portfolio if they are not included in
+	// the prices
+	Dividends float64 `json:"dividends"`
+	// Date of the first withdrawal which could not be paid in full in the
+	// format `2006/01/02`, empty if the portfolio lasted
+	DepletedOn string `json:"depletedOn,omitempty"`
+	// Total fees of all transactions
+	Fees float64 `json:"fees"`
+	// Total taxes paid
+	Taxes float64 `json:"taxes"`
+	// Value left to the investor after selling the portfolio and paying the
+	// taxes due on the end date
+	FinalValueAfterTax float64 `json:"finalValueAfterTax"`
+	// Internal rate of return in percent
+	IRR float64 `json:"irr"`
+	// Internal rate of return in percent if the portfolio is sold and taxed
+	// on the end date
+	IRRAfterTax float64 `json:"irrAfterTax"`
+	// Portfolio values on the evaluation dates in money of the start date if
+	// a CPI is given
+	RealValues []float64 `json:"realValues,omitempty"`
+	// Portfolio value on the end date in money of the start date
+	RealFinalValue float64 `json:"realFinalValue,omitempty"`
+	// Internal rate of return in percent after inflation
+	RealIRR float64 `json:"realIRR,omitempty"`
+	Metrics Metrics `json:"metrics"`
+	// Metrics of the values in money of the start date
+	RealMetrics *Metrics      `json:"realMetrics,omitempty"`
+	Ledger      []LedgerEntry `json:"transactions"`
+}
 
-	pValues, dates, err := Simulate(startDate, p, inc, strat)
+// SimulateStratOnRef simulates `strat` on a portfolio holding the symbols of
+// `cfg.Portfolio`, rebalanced towards their weights.
+func SimulateStratOnRef(cfg SimConfig, strat Strategy) (StratResult, error) {
+	return SimulateStratOnRefContext(context.Background(), cfg, strat)
+}
+
+// SimulateStratOnRefContext is like `SimulateStratOnRef` but stops with the
+// error of `ctx` once it is done. Every call builds its own portfolio, so
+// strategies built for different calls can be simulated concurrently.
+func SimulateStratOnRefContext(ctx context.Context, cfg SimConfig, strat Strategy) (res StratResult, err error) {
+	opts := append(cfg.Options[:len(cfg.Options):len(cfg.Options)], WithCalendar(cfg.calendar()))
+	p, err := NewAllocationPortfolio(cfg.Portfolio, cfg.PriceS, cfg.Fees, opts...)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 
-	irr := p.CalcIRR(time.Now())
+	inc, err := cfg.Income.Build(cfg.Start)
+	if err != nil {
+		return
+	}
 
-	return pValues, dates, irr
+	return simulateResult(ctx, cfg, p, inc, strat)
 }
 
-func getRefPortfolio(symbol string, fixedFees float64, varFees float64) Portfolio {
-	sACWI := &Stock{Symbol: symbol}
+// simulateResult simulates `strat` on `p` with income from `inc` in the
+// setting of `cfg` and summarizes the outcome.
+func simulateResult(ctx context.Context, cfg SimConfig, p Portfolio, inc Income, strat Strategy) (res StratResult, err error) {
+	values, evalDates, err := simulate(ctx, cfg.Start, cfg.End, p, inc, strat, cfg.calendar())
+	if err != nil {
+		return
+	}
 
-	stocks := map[*Stock]int64{
-		sACWI: 0,
+	irr, err := p.CalcIRR(cfg.End)
+	if err != nil {
+		return
+	}
+
+	for i, value := range values {
+		res.Values = append(res.Values, math.Round(value))
+		res.Dates = append(res.Dates, evalDates[i].Format("2006/01/02"))
+	}
+	finalValue, err := p.TotalValue(cfg.End)
+	if err != nil {
+		return
+	}
+	afterTax, err := p.afterTaxValue(cfg.End)
+	if err != nil {
+		return
+	}
+	irrAfterTax, err := irrPercent(p.cashFlows(), cfg.End, afterTax)
+	if err != nil {
+		return
 	}
 
-	goalRatios := map[*Stock]float64{
-		sACWI: 1.0,
+	res.FinalValue = RoundTo(2, finalValue)
+	res.FinalValueAfterTax = RoundTo(2, afterTax)
+	res.IRR = irr
+	res.IRRAfterTax = irrAfterTax
+	res.Metrics = calcMetrics(evalDates, values, p.cashFlows(), riskFreeRate)
+	res.Ledger = p.Ledger()
+	for _, entry := range res.Ledger {
+		res.Fees += entry.Fees
+		switch entry.Type {
+		case "income":
+			res.PaidIn += entry.Amount
+		case "withdrawal":
+			res.Withdrawn -= entry.Amount
+		case "dividend":
+			res.Dividends += entry.Amount
+		case "tax":
+			res.Taxes -= entry.Amount
+		}
+	}
+	res.Taxes = RoundTo(2, res.Taxes)
+	res.Dividends = RoundTo(2, res.Dividends)
+	res.Fees = RoundTo(2, res.Fees)
+	res.Withdrawn = RoundTo(2, res.Withdrawn)
+	if depleted := p.depletedOn(); !depleted.IsZero() {
+		res.DepletedOn = depleted.Format("2006/01/02")
+	}
+
+	if cfg.CPI != nil {
+		err = res.addRealValues(cfg, evalDates, values, finalValue, p)
+	}
+	return
+}
+
+// addRealValues converts the values of the result and the final value into
+// money of the start date and calculates the real IRR and metrics of `p`.
+func (res *StratResult) addRealValues(cfg SimConfig, evalDates []time.Time, values []float64, finalValue float64, p Portfolio) error {
+	realValues := make([]float64, 0, len(values))
+	for i, value := range values {
+		real, err := cfg.CPI.Deflate(value, evalDates[i], cfg.Start)
+		if err != nil {
+			return err
+		}
+		realValues = append(realValues, real)
+		res.RealValues = append(res.RealValues, math.Round(real))
+	}
+
+	realFlows, err := cfg.CPI.deflateFlows(p.cashFlows(), cfg.Start)
+	if err != nil {
+		return err
 	}
+	metrics := calcMetrics(evalDates, realValues, realFlows, riskFreeRate)
+	res.RealMetrics = &metrics
 
-	startCash := 0.0
-	p, err := NewMultiPortfolio(startCash, stocks, goalRatios, fixedFees, varFees)
+	realFinal, err := cfg.CPI.Deflate(finalValue, cfg.End, cfg.Start)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	res.RealFinalValue = RoundTo(2, realFinal)
 
-	return p
+	res.RealIRR, err = cfg.CPI.realIRR(p.cashFlows(), cfg.End, finalValue, cfg.Start)
+	return err
 }
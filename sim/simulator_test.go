@@ -0,0 +1,121 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2010, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", mock.Anything).Return(100.0, nil)
+
+	allocs := []Allocation{{Symbol: "TEST.DE", Weight: 1.0}}
+	p, err := NewAllocationPortfolio(allocs, priceP, nil)
+	assert.Nil(t, err)
+	inc := NewIncome(start, 1000.0)
+	strat := NewMonthlyStrategy(start)
+
+	pValues, dates, err := Simulate(start, end, p, inc, strat, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2010/01/29", "2010/02/26", "2010/03/31"}, dates,
+		"Portfolio should be evaluated on the last weekday of every month")
+	assert.Equal(t, []float64{1000.0, 2000.0, 3000.0}, pValues, "Portfolio values wrong")
+	for _, vol := range p.(*multiPortfolio).stocks {
+		assert.Equal(t, 30.0, vol, "Number of shares wrong")
+	}
+
+	p, _ = NewAllocationPortfolio(allocs, priceP, nil)
+	_, _, err = Simulate(end, start, p, inc, strat, nil)
+	assert.NotNil(t, err, "Expected an error for a start after the end")
+
+	// The portfolio is evaluated on the last trading day of a given calendar
+	series := make(map[string]float64)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if (WeekdayCalendar{}).IsTradingDay(day) && day.Format("2006-01-02") != "2010-01-29" {
+			series[day.Format("2006-01-02")] = 100.0
+		}
+	}
+	p, _ = NewAllocationPortfolio(allocs, priceP, nil, WithCalendar(NewSeriesCalendar(series)))
+	_, dates, err = Simulate(start, end, p, NewIncome(start, 1000.0), NewMonthlyStrategy(start), NewSeriesCalendar(series))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2010/01/28", "2010/02/26", "2010/03/31"}, dates,
+		"Portfolio should be evaluated on the last trading day of every month")
+}
+
+func TestSimulateStratOnRefMultiAsset(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2010, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "STOCKS", mock.Anything).Return(100.0, nil)
+	priceP.On("GetPrice", "BONDS", mock.Anything).Return(50.0, nil)
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "STOCKS", Weight: 0.6}, {Symbol: "BONDS", Weight: 0.4}},
+		PriceS:    priceP,
+		Income:    IncomeSpec{Monthly: 1000.0},
+	}
+	res, err := SimulateStratOnRef(cfg, NewMonthlyStrategy(start))
+	assert.Nil(t, err)
+	assert.Equal(t, 3000.0, res.FinalValue)
+
+	shares := make(map[string]float64)
+	for _, entry := range res.Ledger {
+		shares[entry.Symbol] += entry.Shares
+	}
+	assert.Equal(t, 18.0, shares["STOCKS"], "Number of shares wrong")
+	assert.Equal(t, 24.0, shares["BONDS"], "Number of shares wrong")
+}
+
+func TestSimulateStratOnRefCanceled(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2010, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", mock.Anything).Return(100.0, nil)
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "TEST.DE", Weight: 1.0}},
+		PriceS:    priceP,
+		Income:    IncomeSpec{Monthly: 1000.0},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := SimulateStratOnRefContext(ctx, cfg, NewMonthlyStrategy(start))
+	assert.Equal(t, context.Canceled, err, "Expected the simulation to stop")
+}
+
+func TestSimulateStratOnRefMissingPrice(t *testing.T) {
+	start := time.Date(2010, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2010, 3, 31, 12, 0, 0, 0, time.UTC)
+	gap := time.Date(2010, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "TEST.DE", mock.MatchedBy(func(date time.Time) bool {
+		return date.Before(gap)
+	})).Return(100.0, nil)
+	priceP.On("GetPrice", "TEST.DE", mock.Anything).Return(0.0, errors.New("No price"))
+
+	cfg := SimConfig{
+		Start:     start,
+		End:       end,
+		Portfolio: []Allocation{{Symbol: "TEST.DE", Weight: 1.0}},
+		PriceS:    priceP,
+		Income:    IncomeSpec{Monthly: 1000.0},
+	}
+	_, err := SimulateStratOnRef(cfg, NewMonthlyStrategy(start))
+	assert.EqualError(t, err, "No price", "Expected the valuation to fail")
+}
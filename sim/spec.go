@@ -0,0 +1,281 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A StrategySpec describes a strategy by its type and parameters. It can be
+// read from JSON or YAML and is turned into a Strategy with `Build`.
+type StrategySpec struct {
+	Name string `json:"name" yaml:"name"`
+	// Type of the strategy as registered with `RegisterStrategy`
+	Type string `json:"type" yaml:"type"`
+	// Day of the month to invest on or after, defaults to 14
+	MinDay int `json:"minDay,omitempty" yaml:"minDay,omitempty"`
+	// Months to invest in
+	Months []int `json:"months,omitempty" yaml:"months,omitempty"`
+	// Minimum relative drawdown to invest at, e.g. 0.3 for 30%
+	Drawdown float64 `json:"drawdown,omitempty" yaml:"drawdown,omitempty"`
+	// Days to wait between periodic investments
+	WaitDays int `json:"waitDays,omitempty" yaml:"waitDays,omitempty"`
+	// Monthly amount to withdraw
+	Amount float64 `json:"amount,omitempty" yaml:"amount,omitempty"`
+	// Annual withdrawal rate, e.g. 0.04 for 4%
+	Rate float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+	// Annual raise of withdrawals, e.g. 0.02 for 2%
+	Inflation float64 `json:"inflation,omitempty" yaml:"inflation,omitempty"`
+	// Band around the withdrawal rate before adjusting withdrawals, defaults
+	// to 0.2
+	Guardrail float64 `json:"guardrail,omitempty" yaml:"guardrail,omitempty"`
+	// Adjustment of withdrawals when leaving the guardrails, defaults to 0.1
+	Adjustment float64 `json:"adjustment,omitempty" yaml:"adjustment,omitempty"`
+	// Fees of the strategy, overriding the fees of the scenario
+	Fees *FeeSpec `json:"fees,omitempty" yaml:"fees,omitempty"`
+	// Handling of dividends by the strategy as named by `DividendMode`,
+	// overriding the dividends of the scenario
+	Dividends string `json:"dividends,omitempty" yaml:"dividends,omitempty"`
+	// Further parameters of strategies registered outside of this package
+	Params map[string]float64 `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// A BuildEnv holds the setting a strategy is built for.
+type BuildEnv struct {
+	Start time.Time
+	// Symbol to evaluate drawdowns on
+	RefSymbol string
+	PriceS    PriceSource
+}
+
+// A StrategyFactory builds a new Strategy from a spec. Strategies keep state
+// while they are simulated, so a new one has to be built for every run.
+type StrategyFactory func(spec StrategySpec, env BuildEnv) (Strategy, error)
+
+var (
+	// Fees of strategies trading every month if neither their spec nor the
+	// scenario gives any, like the monthly plans of many large brokers
+	MonthlyFees = FeeSpec{Var: 0.015}
+	// Fees of all other strategies if neither their spec nor the scenario
+	// gives any
+	LumpSumFees = FeeSpec{Type: "flat", Fixed: 56.0}
+)
+
+// A strategyType is a registered type of strategies.
+type strategyType struct {
+	factory StrategyFactory
+	// Fees of the strategies of the type if neither their spec nor the
+	// scenario gives any
+	fees *FeeSpec
+}
+
+var registry = struct {
+	sync.RWMutex
+	m map[string]strategyType
+}{m: map[string]strategyType{
+	"MidMonth":             {buildMidMonth, &MonthlyFees},
+	"FixedMonths":          {buildFixedMonths, &LumpSumFees},
+	"MinDrawdown":          {buildMinDrawdown, &LumpSumFees},
+	"AdaptivePeriodic":     {buildAdaptivePeriodic, &LumpSumFees},
+	"NoInvest":             {buildNoInvest, &LumpSumFees},
+	"FixedWithdrawal":      {buildFixedWithdrawal, &MonthlyFees},
+	"FourPercentRule":      {buildFourPercentRule, &MonthlyFees},
+	"PercentageWithdrawal": {buildPercentageWithdrawal, &MonthlyFees},
+	"Guardrails":           {buildGuardrails, &MonthlyFees},
+}}
+
+// RegisterStrategy makes a strategy type available to specs. Its strategies
+// pay `fees` if neither their spec nor the scenario gives any. Registering
+// an existing type replaces its factory and fees.
+func RegisterStrategy(stratType string, factory StrategyFactory, fees FeeSpec) {
+	registry.Lock()
+	registry.m[stratType] = strategyType{factory: factory, fees: &fees}
+	registry.Unlock()
+}
+
+// StrategyTypes returns the sorted names of all registered strategy types.
+func StrategyTypes() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var types []string
+	for t := range registry.m {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Build creates a new strategy from the spec.
+func (spec StrategySpec) Build(env BuildEnv) (Strategy, error) {
+	registry.RLock()
+	t, ok := registry.m[spec.Type]
+	registry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s: Unknown strategy type %q", spec.DisplayName(), spec.Type)
+	}
+
+	strat, err := t.factory(spec, env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spec.DisplayName(), err)
+	}
+	return strat, nil
+}
+
+// FeeModel returns the fee model of the strategy of the spec. The fees of the
+// spec take precedence over `scenarioFees`. Without either, the fees of the
+// strategy type apply.
+func (spec StrategySpec) FeeModel(scenarioFees *FeeSpec) (FeeModel, error) {
+	if spec.Fees != nil {
+		return spec.Fees.Model()
+	}
+	if scenarioFees != nil {
+		return scenarioFees.Model()
+	}
+
+	registry.RLock()
+	t, ok := registry.m[spec.Type]
+	registry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown strategy type %q", spec.Type)
+	}
+	return t.fees.Model()
+}
+
+// DisplayName returns the name of the spec or its type if it has no name.
+func (spec StrategySpec) DisplayName() string {
+	if spec.Name == "" {
+		return spec.Type
+	}
+	return spec.Name
+}
+
+func (spec StrategySpec) minDay() (int, error) {
+	if spec.MinDay == 0 {
+		return 14, nil
+	}
+	if spec.MinDay < 1 || spec.MinDay > 31 {
+		return 0, fmt.Errorf("Invalid minDay %d", spec.MinDay)
+	}
+	return spec.MinDay, nil
+}
+
+func (spec StrategySpec) relVal() (float64, error) {
+	if spec.Drawdown <= 0.0 || spec.Drawdown >= 1.0 {
+		return 0.0, fmt.Errorf("Drawdown %v not between 0 and 1", spec.Drawdown)
+	}
+	return 1.0 - spec.Drawdown, nil
+}
+
+// rate returns the withdrawal rate of the spec, which defaults to 4%.
+func (spec StrategySpec) rate() (float64, error) {
+	if spec.Rate == 0.0 {
+		return 0.04, nil
+	}
+	if spec.Rate < 0.0 || spec.Rate > 1.0 {
+		return 0.0, fmt.Errorf("Rate %v not between 0 and 1", spec.Rate)
+	}
+	return spec.Rate, nil
+}
+
+func buildMidMonth(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	minDay, err := spec.minDay()
+	if err != nil {
+		return nil, err
+	}
+	strat := NewMonthlyStrategy(env.Start)
+	strat.(*MidMonth).minDay = minDay
+	return strat, nil
+}
+
+func buildFixedMonths(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	if len(spec.Months) == 0 {
+		return nil, errors.New("No months given")
+	}
+
+	var months []time.Month
+	for _, m := range spec.Months {
+		if m < 1 || m > 12 {
+			return nil, fmt.Errorf("Invalid month %d", m)
+		}
+		months = append(months, time.Month(m))
+	}
+
+	minDay, err := spec.minDay()
+	if err != nil {
+		return nil, err
+	}
+	strat := NewFixedMonthsStrategy(env.Start, months)
+	strat.(*FixedMonths).minDay = minDay
+	return strat, nil
+}
+
+func buildMinDrawdown(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	relVal, err := spec.relVal()
+	if err != nil {
+		return nil, err
+	}
+	return NewMinDrawdown(relVal, env.RefSymbol, env.PriceS), nil
+}
+
+func buildAdaptivePeriodic(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	relVal, err := spec.relVal()
+	if err != nil {
+		return nil, err
+	}
+	if spec.WaitDays <= 0 {
+		return nil, errors.New("waitDays must be positive")
+	}
+	waitTime := time.Duration(spec.WaitDays*24) * time.Hour
+	return NewAdaptivePeriodic(env.Start, waitTime, relVal, env.RefSymbol, env.PriceS), nil
+}
+
+func buildNoInvest(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	return &NoInvest{}, nil
+}
+
+func buildFixedWithdrawal(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	if spec.Amount <= 0.0 {
+		return nil, errors.New("amount must be positive")
+	}
+	return NewFixedWithdrawal(spec.Amount, spec.Inflation), nil
+}
+
+func buildFourPercentRule(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	rate, err := spec.rate()
+	if err != nil {
+		return nil, err
+	}
+	return NewFourPercentRule(rate, spec.Inflation), nil
+}
+
+func buildPercentageWithdrawal(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	rate, err := spec.rate()
+	if err != nil {
+		return nil, err
+	}
+	return NewPercentageWithdrawal(rate), nil
+}
+
+func buildGuardrails(spec StrategySpec, env BuildEnv) (Strategy, error) {
+	rate, err := spec.rate()
+	if err != nil {
+		return nil, err
+	}
+
+	guardrail, adjustment := spec.Guardrail, spec.Adjustment
+	if guardrail == 0.0 {
+		guardrail = 0.2
+	}
+	if adjustment == 0.0 {
+		adjustment = 0.1
+	}
+	if guardrail < 0.0 || adjustment < 0.0 || adjustment > 1.0 {
+		return nil, fmt.Errorf("Invalid guardrail %v or adjustment %v", guardrail, adjustment)
+	}
+	return NewGuardrails(rate, spec.Inflation, guardrail, adjustment), nil
+}
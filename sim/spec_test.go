@@ -0,0 +1,166 @@
+package sim
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const scenarioYAML = `
+name: test
+symbol: TEST.DE
+from: "2010-01-01"
+income:
+  monthly: 500
+strategies:
+  - name: Monthly
+    type: MidMonth
+    minDay: 3
+  - name: Biyearly
+    type: FixedMonths
+    months: [4, 10]
+  - name: 6m||30%Drawdown
+    type: AdaptivePeriodic
+    waitDays: 182
+    drawdown: 0.3
+  - type: NoInvest
+`
+
+func TestBuildStrategySpecs(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	env := BuildEnv{Start: start, RefSymbol: "TEST.DE", PriceS: &mockPriceProvider{}}
+
+	strat, err := StrategySpec{Type: "MidMonth"}.Build(env)
+	assert.Nil(t, err)
+	assert.Equal(t, 14, strat.(*MidMonth).minDay, "minDay should default to 14")
+
+	strat, err = StrategySpec{Type: "FixedMonths", Months: []int{4, 10}, MinDay: 2}.Build(env)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strat.(*FixedMonths).minDay)
+	assert.Equal(t, map[time.Month]bool{4: true, 10: true}, strat.(*FixedMonths).investMonths)
+
+	strat, err = StrategySpec{Type: "MinDrawdown", Drawdown: 0.3}.Build(env)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.7, strat.(*MinDrawdown).relVal, 1e-9)
+	assert.Equal(t, "TEST.DE", strat.(*MinDrawdown).refSymbol)
+
+	strat, err = StrategySpec{Type: "AdaptivePeriodic", Drawdown: 0.3, WaitDays: 10}.Build(env)
+	assert.Nil(t, err)
+	assert.Equal(t, 10*24*time.Hour, strat.(*AdaptivePeriodic).waitTime)
+
+	strat, err = StrategySpec{Type: "NoInvest"}.Build(env)
+	assert.Nil(t, err)
+	assert.IsType(t, &NoInvest{}, strat)
+
+	invalid := []StrategySpec{
+		{Type: "Unknown"},
+		{Type: "FixedMonths"},
+		{Type: "FixedMonths", Months: []int{13}},
+		{Type: "MidMonth", MinDay: 32},
+		{Type: "MinDrawdown", Drawdown: 1.5},
+		{Type: "AdaptivePeriodic", Drawdown: 0.3},
+	}
+	for _, spec := range invalid {
+		_, err = spec.Build(env)
+		assert.NotNil(t, err, "Expected an error for ", spec)
+	}
+}
+
+func TestStrategySpecFeeModel(t *testing.T) {
+	fees, err := StrategySpec{Type: "MidMonth"}.FeeModel(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Rate: 0.015}, fees, "Monthly strategies should pay variable fees")
+
+	fees, err = StrategySpec{Type: "Guardrails"}.FeeModel(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Rate: 0.015}, fees, "Withdrawals should pay variable fees")
+
+	fees, err = StrategySpec{Type: "MinDrawdown"}.FeeModel(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, FlatFee{Amount: 56.0}, fees, "Lump sums should pay fixed fees")
+
+	fees, err = StrategySpec{Type: "MidMonth"}.FeeModel(&FeeSpec{Type: "flat", Fixed: 5.0})
+	assert.Nil(t, err)
+	assert.Equal(t, FlatFee{Amount: 5.0}, fees, "Fees of the scenario should apply")
+
+	spec := StrategySpec{Type: "MidMonth", Fees: &FeeSpec{Fixed: 1.0}}
+	fees, err = spec.FeeModel(&FeeSpec{Type: "flat", Fixed: 5.0})
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Fixed: 1.0}, fees, "Fees of the spec should take precedence")
+
+	_, err = StrategySpec{Type: "Unknown"}.FeeModel(nil)
+	assert.NotNil(t, err)
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	RegisterStrategy("Custom", func(spec StrategySpec, env BuildEnv) (Strategy, error) {
+		if spec.Params["fail"] > 0 {
+			return nil, errors.New("Test error")
+		}
+		return &NoInvest{}, nil
+	}, FeeSpec{Fixed: 1.0})
+	assert.Contains(t, StrategyTypes(), "Custom")
+
+	fees, err := StrategySpec{Type: "Custom"}.FeeModel(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, PercentageFee{Fixed: 1.0}, fees, "Fees of the type should apply")
+
+	_, err = StrategySpec{Type: "Custom"}.Build(BuildEnv{})
+	assert.Nil(t, err)
+
+	_, err = StrategySpec{Type: "Custom", Params: map[string]float64{"fail": 1}}.Build(BuildEnv{})
+	assert.NotNil(t, err)
+}
+
+func TestLoadScenario(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := path.Join(dir, "scenario.yaml")
+	assert.Nil(t, ioutil.WriteFile(yamlPath, []byte(scenarioYAML), 0644))
+
+	sc, err := LoadScenario(yamlPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", sc.Name)
+	assert.Equal(t, "TEST.DE", sc.Symbol)
+	assert.Equal(t, 500.0, sc.Income.Monthly)
+	assert.Nil(t, sc.Fees)
+	assert.Len(t, sc.Strategies, 4)
+	assert.Equal(t, []int{4, 10}, sc.Strategies[1].Months)
+	assert.Equal(t, "NoInvest", sc.Strategies[3].DisplayName())
+
+	jsonPath := path.Join(dir, "other.json")
+	assert.Nil(t, ioutil.WriteFile(jsonPath, []byte(`{
+		"fees": {"fixed": 5, "var": 0.01},
+		"strategies": [{"name": "Monthly", "type": "MidMonth"}]
+	}`), 0644))
+
+	sc, err = LoadScenario(jsonPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "other", sc.Name, "Name should default to the file name")
+	assert.Equal(t, &FeeSpec{Fixed: 5, Var: 0.01}, sc.Fees)
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	invalid := []string{
+		// Typo in parameter name
+		`{"strategies": [{"type": "MinDrawdown", "drawdwn": 0.3}]}`,
+		// No strategies
+		`{"symbol": "SPY"}`,
+		// Duplicate names
+		`{"strategies": [{"type": "NoInvest"}, {"type": "NoInvest"}]}`,
+		// Unknown type
+		`{"strategies": [{"type": "Foo"}]}`,
+		// Unknown execution price
+		`{"execution": {"price": "vwap"}, "strategies": [{"type": "NoInvest"}]}`,
+	}
+	for _, data := range invalid {
+		_, err := ParseScenario([]byte(data), true)
+		assert.NotNil(t, err, "Expected an error for ", data)
+
+		_, err = ParseScenario([]byte(data), false)
+		assert.NotNil(t, err, "Expected an error for YAML ", data)
+	}
+}
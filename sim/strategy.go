@@ -40,7 +40,7 @@ type NoInvest struct {
 type WithDrawdown struct {
 	relVal    float64
 	refSymbol string
-	priceP    priceProvider
+	priceP    PriceSource
 	lastTop   float64
 }
 
@@ -86,7 +86,7 @@ func NewFixedMonthsStrategy(startDate time.Time, months []time.Month) Strategy {
 // NewMinDrawdown creates a new strategy investing when the stock behind
 // `refSymbol` suffered a minimum relative drawdown to `relVal` from its last
 // known top value.
-func NewMinDrawdown(relVal float64, refSymbol string, priceP priceProvider) Strategy {
+func NewMinDrawdown(relVal float64, refSymbol string, priceP PriceSource) Strategy {
 	return &MinDrawdown{WithDrawdown{relVal, refSymbol, priceP, 0.0}}
 }
 
@@ -94,7 +94,7 @@ func NewMinDrawdown(relVal float64, refSymbol string, priceP priceProvider) Stra
 // has passed since the last investment or when `refSymbol` suffered a minimum
 // relative drawdown to `relVal` from its last known top value.
 func NewAdaptivePeriodic(startDate time.Time, waitTime time.Duration,
-	relVal float64, refSymbol string, priceP priceProvider) Strategy {
+	relVal float64, refSymbol string, priceP PriceSource) Strategy {
 
 	return &AdaptivePeriodic{
 		waitTime:     waitTime,
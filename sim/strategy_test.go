@@ -5,7 +5,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/sgasse/finca/av"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -24,14 +23,19 @@ func (m *mockPortfolio) SetStart(date time.Time) {
 	_ = m.Called(date)
 }
 
-func (m *mockPortfolio) TotalValue(date time.Time) float64 {
+func (m *mockPortfolio) TotalValue(date time.Time) (float64, error) {
 	args := m.Called(date)
-	return args.Get(0).(float64)
+	return args.Get(0).(float64), args.Error(1)
 }
 
-func (m *mockPortfolio) CalcIRR(date time.Time) float64 {
+func (m *mockPortfolio) CalcIRR(date time.Time) (float64, error) {
 	args := m.Called(date)
-	return args.Get(0).(float64)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *mockPortfolio) Ledger() []LedgerEntry {
+	args := m.Called()
+	return args.Get(0).([]LedgerEntry)
 }
 
 func (m *mockPortfolio) getCashBalance() float64 {
@@ -39,6 +43,11 @@ func (m *mockPortfolio) getCashBalance() float64 {
 	return args.Get(0).(float64)
 }
 
+func (m *mockPortfolio) cashFlows() []cashFlow {
+	args := m.Called()
+	return args.Get(0).([]cashFlow)
+}
+
 func (m *mockPortfolio) transact(tr transaction) {
 	_ = m.Called(tr)
 }
@@ -48,6 +57,26 @@ func (m *mockPortfolio) rebalance(reinvest float64, date time.Time) error {
 	return args.Error(0)
 }
 
+func (m *mockPortfolio) withdraw(amount float64, date time.Time) error {
+	args := m.Called(amount, date)
+	return args.Error(0)
+}
+
+func (m *mockPortfolio) payDividends(since time.Time, date time.Time) error {
+	args := m.Called(since, date)
+	return args.Error(0)
+}
+
+func (m *mockPortfolio) afterTaxValue(date time.Time) (float64, error) {
+	args := m.Called(date)
+	return args.Get(0).(float64), args.Error(1)
+}
+
+func (m *mockPortfolio) depletedOn() time.Time {
+	args := m.Called()
+	return args.Get(0).(time.Time)
+}
+
 type mockPriceProvider struct {
 	mock.Mock
 }
@@ -92,7 +121,7 @@ func TestNewAdaptivePeriodic(t *testing.T) {
 		waitTime,
 		0.7,
 		"TEST.DE",
-		&av.AvProvider{})
+		&mockPriceProvider{})
 
 	assert.Equal(
 		t,
@@ -0,0 +1,336 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// A LotMethod determines which lots of a stock are sold first.
+type LotMethod int
+
+const (
+	// FIFO sells the lots bought first.
+	FIFO LotMethod = iota
+	// SpecificLot sells the lots with the highest cost basis first, the
+	// specific lot identification which defers most taxes.
+	SpecificLot
+)
+
+var lotMethodNames = map[LotMethod]string{
+	FIFO:        "fifo",
+	SpecificLot: "specific",
+}
+
+func (method LotMethod) String() string {
+	return lotMethodNames[method]
+}
+
+// An AccountType determines how the money in a portfolio is taxed.
+type AccountType int
+
+const (
+	// Taxable accounts pay taxes on realized gains and dividends.
+	Taxable AccountType = iota
+	// PreTax accounts are funded with untaxed income. Gains and dividends
+	// are not taxed, but all withdrawals are.
+	PreTax
+	// TaxFree accounts pay no taxes at all.
+	TaxFree
+)
+
+var accountTypeNames = map[AccountType]string{
+	Taxable: "taxable",
+	PreTax:  "preTax",
+	TaxFree: "taxFree",
+}
+
+func (account AccountType) String() string {
+	return accountTypeNames[account]
+}
+
+// TaxRules describe the taxes of a portfolio. Rates are given as fractions,
+// e.g. 0.25 for 25%.
+type TaxRules struct {
+	// Tax rate of realized capital gains
+	CapitalGains float64
+	// Tax rate of dividends
+	Dividends float64
+	// Tax rate of withdrawals from pre-tax accounts
+	Withdrawals float64
+	// Income which is tax-free every calendar year
+	Allowance float64
+	Lots      LotMethod
+	Account   AccountType
+}
+
+// WithTax taxes the portfolio according to `rules`. Realized losses are
+// carried forward and offset later gains and dividends. The allowance of a
+// year applies to gains and dividends after losses and to withdrawals from
+// pre-tax accounts.
+func WithTax(rules TaxRules) PortfolioOption {
+	return func(p *multiPortfolio) {
+		p.tax = &taxState{rules: rules, lots: make(map[*Stock][]lot)}
+	}
+}
+
+// A lot is a number of shares of a stock bought at the same time.
+type lot struct {
+	shares float64
+	// Cost per share including the fees of the purchase
+	cost float64
+}
+
+// A taxState keeps track of the lots and the allowance of a portfolio.
+type taxState struct {
+	rules TaxRules
+	lots  map[*Stock][]lot
+	// Calendar year of `allowanceLeft`
+	year          int
+	allowanceLeft float64
+	// Realized losses which did not offset income yet
+	losses float64
+}
+
+// A taxTransaction pays taxes out of the portfolio.
+type taxTransaction struct {
+	date time.Time
+	// Stock whose sale or dividend is taxed, nil for withdrawals
+	stock  *Stock
+	amount float64
+}
+
+// due returns the tax at `rate` on `income` received on `date`. Negative
+// income is a loss which is carried forward.
+func (s *taxState) due(income float64, rate float64, date time.Time) float64 {
+	if date.Year() != s.year {
+		s.year = date.Year()
+		s.allowanceLeft = s.rules.Allowance
+	}
+
+	if income < 0 {
+		s.losses -= income
+		return 0.0
+	}
+
+	offset := math.Min(income, s.losses)
+	s.losses -= offset
+	income -= offset
+
+	free := math.Min(income, s.allowanceLeft)
+	s.allowanceLeft -= free
+	income -= free
+
+	return income * rate
+}
+
+// consumeLots removes `shares` of `stock` from its lots in the order of the
+// lot method and returns their cost basis. Shares without a known lot, e.g.
+// those held from the start, are returned as `unknown`.
+func (s *taxState) consumeLots(stock *Stock, shares float64) (basis float64, unknown float64) {
+	lots := s.lots[stock]
+	if s.rules.Lots == SpecificLot {
+		sort.SliceStable(lots, func(i, j int) bool {
+			return lots[i].cost > lots[j].cost
+		})
+	}
+
+	for len(lots) > 0 && shares > 1e-9 {
+		sold := math.Min(shares, lots[0].shares)
+		basis += sold * lots[0].cost
+		shares -= sold
+		lots[0].shares -= sold
+		if lots[0].shares <= 1e-9 {
+			lots = lots[1:]
+		}
+	}
+	s.lots[stock] = lots
+
+	if shares > 1e-9 {
+		unknown = shares
+	}
+	return
+}
+
+// realize removes the lots of `shares` of `stock` sold for `proceeds` after
+// fees and returns the realized gain. Shares of unknown cost are sold without
+// gain.
+func (s *taxState) realize(stock *Stock, shares float64, proceeds float64) float64 {
+	basis, unknown := s.consumeLots(stock, shares)
+	basis += unknown / shares * proceeds
+	return proceeds - basis
+}
+
+// saleTax returns the tax which selling `shares` of `stock` for `proceeds`
+// after fees on `date` would cost, leaving the lots, allowance and losses
+// untouched.
+func (p *multiPortfolio) saleTax(stock *Stock, shares float64, proceeds float64, date time.Time) float64 {
+	if p.tax == nil || p.tax.rules.Account != Taxable || shares <= 0 {
+		return 0.0
+	}
+
+	// Work on a copy which only holds a copy of the lots of the stock
+	s := *p.tax
+	s.lots = map[*Stock][]lot{stock: append([]lot(nil), p.tax.lots[stock]...)}
+	return s.due(s.realize(stock, shares, proceeds), s.rules.CapitalGains, date)
+}
+
+// unrealizedGain returns the gain of selling all lots of `stock` at `price`.
+func (s *taxState) unrealizedGain(stock *Stock, price float64) (gain float64) {
+	for _, l := range s.lots[stock] {
+		gain += l.shares * (price - l.cost)
+	}
+	return
+}
+
+// applyTax updates the lots after the transaction `tr` and pays the taxes
+// due on it.
+func (p *multiPortfolio) applyTax(tr transaction) {
+	s := p.tax
+	if s.rules.Account != Taxable {
+		return
+	}
+
+	switch t := tr.(type) {
+	case *stockTransaction:
+		if t.deltaVolume > 0 {
+			s.lots[t.stock] = append(s.lots[t.stock], lot{
+				shares: t.deltaVolume,
+				cost:   (t.deltaVolume*t.price + t.fees) / t.deltaVolume,
+			})
+			return
+		}
+
+		gain := s.realize(t.stock, -t.deltaVolume, t.delta())
+		p.payTax(t.date, t.stock, s.due(gain, s.rules.CapitalGains, t.date))
+	case *splitTransaction:
+		held := p.stocks[t.stock]
+		factor := held / (held - t.deltaVolume)
+		for i := range s.lots[t.stock] {
+			s.lots[t.stock][i].shares *= factor
+			s.lots[t.stock][i].cost /= factor
+		}
+	case *dividendTransaction:
+		p.payTax(t.date, t.stock, s.due(t.delta(), s.rules.Dividends, t.date))
+	}
+}
+
+func (p *multiPortfolio) payTax(date time.Time, stock *Stock, amount float64) {
+	if amount > 0 {
+		p.transact(&taxTransaction{date: date, stock: stock, amount: amount})
+	}
+}
+
+// payOut pays `amount` out to the investor. Withdrawals from pre-tax
+// accounts are taxed.
+func (p *multiPortfolio) payOut(amount float64, date time.Time) {
+	if p.tax != nil && p.tax.rules.Account == PreTax {
+		tax := p.tax.due(amount, p.tax.rules.Withdrawals, date)
+		p.payTax(date, nil, tax)
+		amount -= tax
+	}
+	if amount > 0 {
+		p.transact(&withdrawalTransaction{date: date, amount: amount})
+	}
+}
+
+// afterTaxValue returns the value left to the investor if the portfolio was
+// sold and paid out on `date`. Sales are taxed without fees.
+func (p *multiPortfolio) afterTaxValue(date time.Time) (float64, error) {
+	value, err := p.TotalValue(date)
+	if err != nil || p.tax == nil {
+		return value, err
+	}
+
+	// Work on a copy to leave the allowance and losses untouched
+	s := *p.tax
+	switch s.rules.Account {
+	case Taxable:
+		gain := 0.0
+		for _, stock := range p.sortedStocks() {
+			price, err := p.price(stock, date)
+			if err != nil {
+				continue
+			}
+			gain += s.unrealizedGain(stock, price)
+		}
+		return value - s.due(gain, s.rules.CapitalGains, date), nil
+	case PreTax:
+		return value - s.due(value, s.rules.Withdrawals, date), nil
+	}
+	return value, nil
+}
+
+func (t *taxTransaction) delta() float64 {
+	return -t.amount
+}
+
+func (t *taxTransaction) inflow() float64 {
+	return 0.0
+}
+
+func (t *taxTransaction) txDate() time.Time {
+	return t.date
+}
+
+// A TaxSpec describes the taxes of a scenario.
+type TaxSpec struct {
+	// Tax rate of realized capital gains, e.g. 0.25 for 25%
+	CapitalGains float64 `json:"capitalGains,omitempty" yaml:"capitalGains,omitempty"`
+	// Tax rate of dividends
+	Dividends float64 `json:"dividends,omitempty" yaml:"dividends,omitempty"`
+	// Tax rate of withdrawals from accounts of the type `preTax`
+	Withdrawals float64 `json:"withdrawals,omitempty" yaml:"withdrawals,omitempty"`
+	// Tax-free income per calendar year
+	Allowance float64 `json:"allowance,omitempty" yaml:"allowance,omitempty"`
+	// Lots sold first, `fifo` (default) or `specific` for the highest cost
+	Lots string `json:"lots,omitempty" yaml:"lots,omitempty"`
+	// One of `taxable` (default), `preTax` or `taxFree`
+	Account string `json:"account,omitempty" yaml:"account,omitempty"`
+}
+
+// Option returns the portfolio option described by the spec.
+func (spec TaxSpec) Option() (PortfolioOption, error) {
+	for name, rate := range map[string]float64{
+		"capitalGains": spec.CapitalGains,
+		"dividends":    spec.Dividends,
+		"withdrawals":  spec.Withdrawals,
+	} {
+		if rate < 0.0 || rate >= 1.0 {
+			return nil, fmt.Errorf("Rate of %s %v not between 0 and 1", name, rate)
+		}
+	}
+	if spec.Allowance < 0.0 {
+		return nil, fmt.Errorf("Allowance %v must not be negative", spec.Allowance)
+	}
+
+	rules := TaxRules{
+		CapitalGains: spec.CapitalGains,
+		Dividends:    spec.Dividends,
+		Withdrawals:  spec.Withdrawals,
+		Allowance:    spec.Allowance,
+	}
+
+	found := spec.Lots == ""
+	for method, name := range lotMethodNames {
+		if name == spec.Lots {
+			rules.Lots, found = method, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Unknown lot method %q", spec.Lots)
+	}
+
+	found = spec.Account == ""
+	for account, name := range accountTypeNames {
+		if name == spec.Account {
+			rules.Account, found = account, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Unknown account type %q", spec.Account)
+	}
+
+	return WithTax(rules), nil
+}
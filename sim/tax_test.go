@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTaxTestPortfolio creates a taxed portfolio holding 10 shares of A
+// bought at 100 and 5 shares bought at 200.
+func newTaxTestPortfolio(t *testing.T, rules TaxRules) (p *multiPortfolio, sA *Stock, priceP *mockPriceProvider) {
+	sA = &Stock{Symbol: "A"}
+	priceP = &mockPriceProvider{}
+
+	p = newTestPortfolio(t, priceP, 10000.0, map[*Stock]float64{sA: 0}, map[*Stock]float64{sA: 1.0}, nil, WithTax(rules))
+
+	p.transact(&stockTransaction{date: time.Date(2019, 1, 2, 12, 0, 0, 0, time.UTC), stock: sA, deltaVolume: 10, price: 100.0})
+	p.transact(&stockTransaction{date: time.Date(2019, 6, 3, 12, 0, 0, 0, time.UTC), stock: sA, deltaVolume: 5, price: 200.0})
+	return
+}
+
+// taxesPaid sums up the taxes in the ledger of `p`.
+func taxesPaid(p *multiPortfolio) (taxes float64) {
+	for _, entry := range p.Ledger() {
+		if entry.Type == "tax" {
+			taxes -= entry.Amount
+		}
+	}
+	return
+}
+
+func TestTaxCapitalGains(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+
+	// FIFO sells the shares bought at 100 with a gain
+	p, sA, _ := newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25})
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -5, price: 150.0})
+	assert.Equal(t, 62.5, taxesPaid(p), "Tax on gain of 250 wrong")
+	assert.Equal(t, 10000.0-2000.0+750.0-62.5, p.getCashBalance())
+
+	// Specific lots sell the shares bought at 200 with a loss, which offsets
+	// later dividends
+	p, sA, _ = newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25, Dividends: 0.25, Lots: SpecificLot})
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -5, price: 150.0})
+	assert.Equal(t, 0.0, taxesPaid(p), "No taxes on a loss")
+	p.transact(&dividendTransaction{date: date, stock: sA, shares: 10, perShare: 30.0})
+	assert.Equal(t, 12.5, taxesPaid(p), "Loss of 250 should offset dividends of 300")
+
+	// The allowance is renewed every year
+	p, sA, _ = newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25, Allowance: 100.0})
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -5, price: 150.0})
+	assert.Equal(t, 37.5, taxesPaid(p))
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -5, price: 50.0})
+	assert.Equal(t, 37.5, taxesPaid(p), "Loss should not be taxed")
+	p.transact(&stockTransaction{date: date.AddDate(1, 0, 0), stock: sA, deltaVolume: -5, price: 300.0})
+	assert.Equal(t, 75.0, taxesPaid(p), "Allowance of the new year should apply after the loss")
+}
+
+func TestTaxSplit(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	p, sA, _ := newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25})
+
+	p.transact(&splitTransaction{date: date, stock: sA, deltaVolume: 15})
+	assert.Equal(t, 30.0, p.stocks[sA])
+
+	// The first 20 shares cost 50 after the split
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -20, price: 60.0})
+	assert.Equal(t, 50.0, taxesPaid(p))
+}
+
+func TestTaxAfterTaxValue(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+
+	p, _, priceP := newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25})
+	priceP.On("GetPrice", "A", date).Return(300.0, nil)
+	value, err := p.TotalValue(date)
+	assert.Nil(t, err)
+	assert.Equal(t, 8000.0+4500.0, value)
+	value, err = p.afterTaxValue(date)
+	assert.Nil(t, err)
+	assert.Equal(t, 8000.0+4500.0-0.25*2500.0, value)
+	assert.Equal(t, 0.0, taxesPaid(p), "Valuing after tax should not pay taxes")
+
+	p, _, priceP = newTaxTestPortfolio(t, TaxRules{Withdrawals: 0.3, Account: PreTax})
+	priceP.On("GetPrice", "A", date).Return(300.0, nil)
+	value, err = p.afterTaxValue(date)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.7*12500.0, value)
+
+	p, _, priceP = newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25, Account: TaxFree})
+	priceP.On("GetPrice", "A", date).Return(300.0, nil)
+	value, err = p.afterTaxValue(date)
+	assert.Nil(t, err)
+	assert.Equal(t, 12500.0, value)
+}
+
+func TestTaxPreTaxWithdrawal(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	p, sA, _ := newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25, Withdrawals: 0.3, Allowance: 1000.0, Account: PreTax})
+
+	// Gains in pre-tax accounts are not taxed
+	p.transact(&stockTransaction{date: date, stock: sA, deltaVolume: -5, price: 150.0})
+	assert.Equal(t, 0.0, taxesPaid(p))
+
+	err := p.withdraw(3000.0, date)
+	assert.Nil(t, err)
+	assert.Equal(t, 600.0, taxesPaid(p), "Withdrawal above the allowance should be taxed")
+	flows := p.cashFlows()
+	assert.Equal(t, 2400.0, flows[len(flows)-1].amount, "Investor should receive the withdrawal after tax")
+	assert.Equal(t, 10000.0-2000.0+750.0-3000.0, p.getCashBalance())
+}
+
+func TestTaxWithdrawal(t *testing.T) {
+	date := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	p, sA, priceP := newTaxTestPortfolio(t, TaxRules{CapitalGains: 0.25})
+	priceP.On("GetPrice", "A", date).Return(300.0, nil)
+	p.fees = FlatFee{Amount: 10.0}
+	p.shareDecimals = 2
+
+	// The sale covers the withdrawal, its fees and its taxes at once
+	err := p.withdraw(9000.0, date)
+	assert.Nil(t, err)
+	sales := 0
+	for _, entry := range p.Ledger() {
+		if entry.Type == "sell" {
+			sales++
+		}
+	}
+	assert.Equal(t, 1, sales, "Fees should only be paid for one sale")
+	assert.Greater(t, taxesPaid(p), 0.0)
+	assert.InDelta(t, 9000.0, -p.Ledger()[len(p.Ledger())-1].Amount, 1e-9)
+	assert.Less(t, p.getCashBalance(), 300.0*0.01, "Not more than a fraction of a share should be sold in excess")
+	assert.Less(t, p.stocks[sA], 15.0)
+}
+
+func TestTaxSpecOption(t *testing.T) {
+	_, err := TaxSpec{CapitalGains: 0.26375, Dividends: 0.26375, Allowance: 1000.0, Lots: "specific"}.Option()
+	assert.Nil(t, err)
+	_, err = TaxSpec{Withdrawals: 0.3, Account: "preTax"}.Option()
+	assert.Nil(t, err)
+
+	for _, spec := range []TaxSpec{
+		{CapitalGains: -0.1},
+		{Dividends: 1.0},
+		{Allowance: -1.0},
+		{Lots: "lifo"},
+		{Account: "roth"},
+	} {
+		_, err := spec.Option()
+		assert.NotNil(t, err, spec)
+	}
+}
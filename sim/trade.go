@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A Trade is a purchase or sale of a real portfolio, e.g. from the
+// transaction history of a broker.
+type Trade struct {
+	Date   time.Time
+	Symbol string
+	ISIN   string
+	// Currency of the prices of the symbol, empty for the currency of the
+	// investor
+	Currency string
+	// Shares bought, negative if sold
+	Shares float64
+	// Price of a share in the currency of the investor
+	Price float64
+	Fees  float64
+}
+
+// SortTrades sorts `trades` by their dates, keeping trades of the same day
+// in their order.
+func SortTrades(trades []Trade) {
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].Date.Before(trades[j].Date)
+	})
+}
+
+// ValidateTrades checks that `trades` are sorted by date, that every trade
+// has a symbol, shares, a positive price and no negative fees, and that no
+// more shares are sold than held.
+func ValidateTrades(trades []Trade) error {
+	if len(trades) == 0 {
+		return errors.New("No trades given")
+	}
+
+	held := make(map[string]float64)
+	for i, trade := range trades {
+		date := trade.Date.Format("2006-01-02")
+		switch {
+		case i > 0 && trade.Date.Before(trades[i-1].Date):
+			return fmt.Errorf("%s: Trades not sorted by date", date)
+		case trade.Symbol == "":
+			return fmt.Errorf("%s: Trade without symbol", date)
+		case trade.Shares == 0.0:
+			return fmt.Errorf("%s: Trade of %s without shares", date, trade.Symbol)
+		case trade.Price <= 0.0:
+			return fmt.Errorf("%s: Price %v of %s not positive", date, trade.Price, trade.Symbol)
+		case trade.Fees < 0.0:
+			return fmt.Errorf("%s: Negative fees of %s", date, trade.Symbol)
+		}
+
+		held[trade.Symbol] += trade.Shares
+		if held[trade.Symbol] < -1e-6 {
+			return fmt.Errorf("%s: Sold more shares of %s than held", date, trade.Symbol)
+		}
+	}
+	return nil
+}
+
+// SimulateTrades evaluates the real portfolio built by `trades` in the
+// setting of `cfg` like a strategy, at least until `ctx` is done. Every
+// trade is made on its date at its price and fees. Money missing for a
+// purchase is paid in on its date, proceeds of sales stay in cash for later
+// purchases. Trades before the start of `cfg` are made on their dates in its
+// first step. The portfolio, fees and income of `cfg` are not used.
+func SimulateTrades(ctx context.Context, cfg SimConfig, trades []Trade) (res StratResult, err error) {
+	if err = ValidateTrades(trades); err != nil {
+		return
+	}
+
+	replay := &tradeReplay{trades: trades, stocks: make(map[string]*Stock)}
+	stocks := make(map[*Stock]float64)
+	for _, trade := range trades {
+		if _, ok := replay.stocks[trade.Symbol]; !ok {
+			stock := &Stock{Symbol: trade.Symbol, ISIN: trade.ISIN, Currency: trade.Currency}
+			replay.stocks[trade.Symbol] = stock
+			stocks[stock] = 0
+		}
+	}
+	// Goal ratios are only used by withdrawals and reinvested dividends
+	goalRatios := make(map[*Stock]float64)
+	for stock := range stocks {
+		goalRatios[stock] = 1.0 / float64(len(stocks))
+	}
+
+	p, err := NewMultiPortfolio(cfg.PriceS, 0.0, stocks, goalRatios, nil, cfg.Options...)
+	if err != nil {
+		return
+	}
+	return simulateResult(ctx, cfg, p, CombinedIncome{}, replay)
+}
+
+// A tradeReplay is a strategy making the trades of a real portfolio.
+type tradeReplay struct {
+	trades []Trade
+	stocks map[string]*Stock
+	// Index of the next trade to make
+	next int
+}
+
+func (s *tradeReplay) tick(date time.Time, p Portfolio) {
+	for ; s.next < len(s.trades) && !s.trades[s.next].Date.After(date); s.next++ {
+		trade := s.trades[s.next]
+		tr := &stockTransaction{
+			date:        trade.Date,
+			stock:       s.stocks[trade.Symbol],
+			deltaVolume: trade.Shares,
+			price:       trade.Price,
+			fees:        trade.Fees,
+		}
+		if missing := -tr.delta() - p.getCashBalance(); missing > 0 {
+			p.transact(&incomeTransaction{date: trade.Date, amount: missing})
+		}
+		p.transact(tr)
+	}
+}
@@ -0,0 +1,65 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSimulateTrades(t *testing.T) {
+	start := time.Date(2021, 8, 2, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 9, 30, 12, 0, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time { return time.Date(2021, month, d, 12, 0, 0, 0, time.UTC) }
+
+	priceP := &mockPriceProvider{}
+	priceP.On("GetPrice", "A", mock.Anything).Return(100.0, nil)
+	priceP.On("GetPrice", "B", mock.Anything).Return(50.0, nil)
+
+	trades := []Trade{
+		{Date: day(8, 4), Symbol: "A", Shares: 5, Price: 100.0, Fees: 5.0},
+		{Date: day(8, 20), Symbol: "A", Shares: -2, Price: 110.0, Fees: 5.0},
+		// Paid from the proceeds of the sale
+		{Date: day(9, 7), Symbol: "B", Shares: 4, Price: 50.0, Fees: 5.0},
+		{Date: day(9, 10), Symbol: "A", Shares: 1, Price: 100.0},
+	}
+	cfg := SimConfig{Start: start, End: end, PriceS: priceP}
+	res, err := SimulateTrades(context.Background(), cfg, trades)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2021/08/31", "2021/09/30"}, res.Dates)
+	assert.Equal(t, []float64{515.0, 600.0}, res.Values)
+	assert.InDelta(t, 595.0, res.PaidIn, 1e-9, "Only money missing for purchases should be paid in")
+	assert.InDelta(t, 600.0, res.FinalValue, 1e-9)
+	assert.InDelta(t, 15.0, res.Fees, 1e-9)
+
+	var incomes []string
+	for _, entry := range res.Ledger {
+		if entry.Type == "income" {
+			incomes = append(incomes, entry.Date)
+		}
+	}
+	assert.Equal(t, []string{"2021-08-04", "2021-09-10"}, incomes)
+}
+
+func TestValidateTrades(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 8, d, 12, 0, 0, 0, time.UTC) }
+
+	assert.NotNil(t, ValidateTrades(nil))
+	assert.Nil(t, ValidateTrades([]Trade{
+		{Date: day(2), Symbol: "A", Shares: 1.5, Price: 10.0},
+		{Date: day(3), Symbol: "A", Shares: -1.5, Price: 10.0},
+	}))
+
+	for _, trades := range [][]Trade{
+		{{Date: day(2), Shares: 1, Price: 10.0}},
+		{{Date: day(2), Symbol: "A", Price: 10.0}},
+		{{Date: day(2), Symbol: "A", Shares: 1}},
+		{{Date: day(2), Symbol: "A", Shares: 1, Price: 10.0, Fees: -1.0}},
+		{{Date: day(2), Symbol: "A", Shares: 1, Price: 10.0}, {Date: day(3), Symbol: "A", Shares: -2, Price: 10.0}},
+		{{Date: day(3), Symbol: "A", Shares: 1, Price: 10.0}, {Date: day(2), Symbol: "A", Shares: 1, Price: 10.0}},
+	} {
+		assert.NotNil(t, ValidateTrades(trades), "Trades %v should be invalid", trades)
+	}
+}
@@ -0,0 +1,194 @@
+package sim
+
+import (
+	"time"
+)
+
+// Withdrawal is a type to be embedded in withdrawal strategies. It pays out
+// an annual amount in monthly installments on the first evaluation day of
+// every month. The embedding strategy determines the annual amount at the
+// first withdrawal and at the start of every following withdrawal year.
+type Withdrawal struct {
+	annualAmount  float64
+	inflation     float64
+	lastWithdrawn time.Time
+	yearStart     time.Time
+}
+
+// FixedWithdrawal withdraws a fixed monthly amount which is raised by
+// `inflation` every year.
+type FixedWithdrawal struct {
+	Withdrawal
+}
+
+// FourPercentRule withdraws `rate` (traditionally 4%) of the portfolio value
+// at the first withdrawal per year. The annual amount is raised by `inflation`
+// every year independent of the performance of the portfolio.
+type FourPercentRule struct {
+	rate float64
+	Withdrawal
+}
+
+// PercentageWithdrawal withdraws `rate` of the current portfolio value per
+// year, so withdrawals follow the performance of the portfolio.
+type PercentageWithdrawal struct {
+	rate float64
+	Withdrawal
+}
+
+// Guardrails withdraws like `FourPercentRule` but adjusts the annual amount
+// by `adjustment` whenever the current withdrawal rate leaves the band of
+// `guardrail` around the initial rate. A cut is made when the rate rises
+// above the band, a raise when it falls below.
+type Guardrails struct {
+	rate       float64
+	guardrail  float64
+	adjustment float64
+	Withdrawal
+}
+
+// NewFixedWithdrawal creates a new strategy withdrawing `monthlyAmount`
+// every month, raised by `inflation` (e.g. 0.02 for 2%) every year.
+func NewFixedWithdrawal(monthlyAmount float64, inflation float64) Strategy {
+	return &FixedWithdrawal{Withdrawal{annualAmount: 12 * monthlyAmount, inflation: inflation}}
+}
+
+// NewFourPercentRule creates a new strategy withdrawing `rate` of the initial
+// portfolio value per year, raised by `inflation` every year.
+func NewFourPercentRule(rate float64, inflation float64) Strategy {
+	return &FourPercentRule{rate: rate, Withdrawal: Withdrawal{inflation: inflation}}
+}
+
+// NewPercentageWithdrawal creates a new strategy withdrawing `rate` of the
+// current portfolio value per year.
+func NewPercentageWithdrawal(rate float64) Strategy {
+	return &PercentageWithdrawal{rate: rate}
+}
+
+// NewGuardrails creates a new strategy withdrawing `rate` of the initial
+// portfolio value per year, raised by `inflation` every year and adjusted by
+// `adjustment` (e.g. 0.1 for 10%) if the current withdrawal rate deviates by
+// more than `guardrail` (e.g. 0.2 for 20%) from `rate`.
+func NewGuardrails(rate float64, inflation float64, guardrail float64, adjustment float64) Strategy {
+	return &Guardrails{
+		rate:       rate,
+		guardrail:  guardrail,
+		adjustment: adjustment,
+		Withdrawal: Withdrawal{inflation: inflation},
+	}
+}
+
+func (s *FixedWithdrawal) tick(date time.Time, p Portfolio) {
+	due, _, newYear := s.due(date)
+	if !due {
+		return
+	}
+	if newYear {
+		s.annualAmount *= 1 + s.inflation
+	}
+	s.withdraw(date, p)
+}
+
+func (s *FourPercentRule) tick(date time.Time, p Portfolio) {
+	state := s.Withdrawal
+	due, first, newYear := s.due(date)
+	if !due {
+		return
+	}
+	if first {
+		value, err := p.TotalValue(date)
+		if err != nil {
+			// Attempt again on the next day
+			s.Withdrawal = state
+			return
+		}
+		s.annualAmount = s.rate * value
+	} else if newYear {
+		s.annualAmount *= 1 + s.inflation
+	}
+	s.withdraw(date, p)
+}
+
+func (s *PercentageWithdrawal) tick(date time.Time, p Portfolio) {
+	state := s.Withdrawal
+	due, _, _ := s.due(date)
+	if !due {
+		return
+	}
+	value, err := p.TotalValue(date)
+	if err != nil {
+		// Attempt again on the next day
+		s.Withdrawal = state
+		return
+	}
+	s.annualAmount = s.rate * value
+	s.withdraw(date, p)
+}
+
+func (s *Guardrails) tick(date time.Time, p Portfolio) {
+	state := s.Withdrawal
+	due, first, newYear := s.due(date)
+	if !due {
+		return
+	}
+
+	value, err := p.TotalValue(date)
+	if err != nil {
+		// Attempt again on the next day
+		s.Withdrawal = state
+		return
+	}
+	if first {
+		s.annualAmount = s.rate * value
+	} else if newYear {
+		s.annualAmount *= 1 + s.inflation
+		if value > 0 {
+			curRate := s.annualAmount / value
+			if curRate > s.rate*(1+s.guardrail) {
+				s.annualAmount *= 1 - s.adjustment
+			} else if curRate < s.rate*(1-s.guardrail) {
+				s.annualAmount *= 1 + s.adjustment
+			}
+		}
+	}
+	s.withdraw(date, p)
+}
+
+// due tells if a withdrawal is due in the month of `date`, if it is the first
+// withdrawal and if a new withdrawal year started since the last one.
+func (w *Withdrawal) due(date time.Time) (due bool, first bool, newYear bool) {
+	if investedThisMonth(date, w.lastWithdrawn) {
+		return
+	}
+	due = true
+
+	if w.yearStart.IsZero() {
+		first = true
+		w.yearStart = date
+		return
+	}
+
+	if next := w.yearStart.AddDate(1, 0, 0); !next.After(date) {
+		newYear = true
+		w.yearStart = next
+	}
+	return
+}
+
+// withdraw pays out the monthly installment of the annual amount. Cash
+// exceeding the next installment, e.g. from income, is invested.
+func (w *Withdrawal) withdraw(date time.Time, p Portfolio) {
+	w.lastWithdrawn = date
+
+	monthly := w.annualAmount / 12
+	if monthly > 0 {
+		if err := p.withdraw(monthly, date); err != nil {
+			return
+		}
+	}
+
+	if cash := p.getCashBalance(); cash > monthly {
+		// Attempt invest
+		_ = p.rebalance(cash-monthly, date)
+	}
+}
@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// approx matches a float argument of a mock call with rounding errors.
+func approx(val float64) interface{} {
+	return mock.MatchedBy(func(x float64) bool {
+		return math.Abs(x-val) < 1e-9
+	})
+}
+
+func TestFixedWithdrawal(t *testing.T) {
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	strat := NewFixedWithdrawal(1000.0, 0.1)
+
+	p := &mockPortfolio{}
+	p.On("getCashBalance").Return(0.0)
+	p.On("withdraw", approx(1000.0), date).Return(nil).Once()
+	strat.tick(date, p)
+
+	// Only withdraw once a month
+	strat.tick(date.Add(24*time.Hour), p)
+
+	nextYear := date.AddDate(1, 0, 0)
+	p.On("withdraw", approx(1100.0), nextYear).Return(nil).Once()
+	strat.tick(nextYear, p)
+	p.AssertExpectations(t)
+}
+
+func TestFourPercentRule(t *testing.T) {
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	strat := NewFourPercentRule(0.04, 0.02)
+
+	p := &mockPortfolio{}
+	p.On("getCashBalance").Return(0.0)
+	// Without a value, the first withdrawal is attempted again on the next day
+	dayBefore := date.AddDate(0, 0, -1)
+	p.On("TotalValue", dayBefore).Return(0.0, errors.New("No price"))
+	strat.tick(dayBefore, p)
+
+	p.On("TotalValue", date).Return(120000.0, nil)
+	p.On("withdraw", approx(400.0), date).Return(nil).Once()
+	strat.tick(date, p)
+
+	// The withdrawal does not depend on the value after the first one
+	nextMonth := date.AddDate(0, 1, 0)
+	p.On("withdraw", approx(400.0), nextMonth).Return(nil).Once()
+	strat.tick(nextMonth, p)
+
+	nextYear := date.AddDate(1, 0, 0)
+	p.On("withdraw", approx(408.0), nextYear).Return(nil).Once()
+	strat.tick(nextYear, p)
+	p.AssertExpectations(t)
+}
+
+func TestPercentageWithdrawal(t *testing.T) {
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	strat := NewPercentageWithdrawal(0.06)
+
+	p := &mockPortfolio{}
+	p.On("getCashBalance").Return(0.0)
+	p.On("TotalValue", date).Return(120000.0, nil)
+	p.On("withdraw", approx(600.0), date).Return(nil).Once()
+	strat.tick(date, p)
+
+	nextMonth := date.AddDate(0, 1, 0)
+	p.On("TotalValue", nextMonth).Return(60000.0, nil)
+	p.On("withdraw", approx(300.0), nextMonth).Return(nil).Once()
+	strat.tick(nextMonth, p)
+	p.AssertExpectations(t)
+}
+
+func TestGuardrails(t *testing.T) {
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	strat := NewGuardrails(0.05, 0.0, 0.2, 0.1)
+
+	p := &mockPortfolio{}
+	p.On("getCashBalance").Return(0.0)
+	p.On("TotalValue", date).Return(120000.0, nil)
+	p.On("withdraw", approx(500.0), date).Return(nil).Once()
+	strat.tick(date, p)
+
+	// A withdrawal rate of 10% is above the upper guardrail of 6%
+	nextYear := date.AddDate(1, 0, 0)
+	p.On("TotalValue", nextYear).Return(60000.0, nil)
+	p.On("withdraw", approx(450.0), nextYear).Return(nil).Once()
+	strat.tick(nextYear, p)
+
+	// A withdrawal rate of 2.7% is below the lower guardrail of 4%
+	inTwoYears := date.AddDate(2, 0, 0)
+	p.On("TotalValue", inTwoYears).Return(200000.0, nil)
+	p.On("withdraw", approx(495.0), inTwoYears).Return(nil).Once()
+	strat.tick(inTwoYears, p)
+	p.AssertExpectations(t)
+}
+
+func TestWithdrawalInvestsCash(t *testing.T) {
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	strat := NewFixedWithdrawal(1000.0, 0.0)
+
+	p := &mockPortfolio{}
+	p.On("withdraw", approx(1000.0), date).Return(nil)
+	p.On("getCashBalance").Return(51000.0)
+	p.On("rebalance", 50000.0, date).Return(nil)
+	strat.tick(date, p)
+	p.AssertExpectations(t)
+}
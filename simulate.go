@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sgasse/finca/analyze"
+	"github.com/sgasse/finca/av"
+	"github.com/sgasse/finca/sim"
+	"github.com/sgasse/finca/synth"
+)
+
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	scenarioFile := fs.String("scenario", "", "scenario file (YAML or JSON), defaults to the strategies of /compare")
+	symbol := fs.String("symbol", "", "symbol to simulate on, overrides the scenario")
+	symbols := fs.String("symbols", "", "weighted list of symbols like VTI@USD:0.6,BND:0.4 to hold, overrides the scenario")
+	from := fs.String("from", "", "start date as 2006-01-02, overrides the scenario")
+	to := fs.String("to", "", "end date as 2006-01-02, overrides the scenario")
+	income := fs.Float64("income", analyze.DefaultMonthlyIncome, "monthly income, overrides the scenario")
+	fixedFees := fs.Float64("fixedFees", 0.0, "fixed fees per transaction for all strategies")
+	varFees := fs.Float64("varFees", 0.0, "variable fees per transaction for all strategies")
+	minFees := fs.Float64("minFees", 0.0, "minimum fees per transaction for all strategies")
+	maxFees := fs.Float64("maxFees", 0.0, "maximum fees per transaction for all strategies")
+	feeType := fs.String("feeType", "", "fee model `flat`, `percentage` or `savingsPlan` for all strategies")
+	rebalance := fs.String("rebalance", "", "rebalance mode `buyOnly`, `band` or `full`, overrides the scenario")
+	band := fs.Float64("band", 0.0, "tolerance band of the rebalance mode band, e.g. 0.05")
+	fractional := fs.Int("fractional", 0, "decimal places of fractional shares, 0 for whole shares")
+	dividends := fs.String("dividends", "", "dividends `adjusted`, `reinvest`, `cash` or `payout`, overrides the scenario")
+	gainsTax := fs.Float64("gainsTax", 0.0, "tax rate of realized capital gains, e.g. 0.25")
+	dividendTax := fs.Float64("dividendTax", 0.0, "tax rate of dividends")
+	withdrawalTax := fs.Float64("withdrawalTax", 0.0, "tax rate of withdrawals from pre-tax accounts")
+	taxAllowance := fs.Float64("taxAllowance", 0.0, "tax-free income per year")
+	lots := fs.String("lots", "", "lots sold first, `fifo` or `specific` for the highest cost")
+	account := fs.String("account", "", "account type `taxable`, `preTax` or `taxFree`")
+	transactions := fs.String("transactions", "", "CSV file with the trades of a real portfolio to compare, overrides the scenario")
+	currency := fs.String("currency", "", "currency of the investor like EUR to convert prices into, overrides the scenario")
+	execution := fs.String("execution", "", "price trades are executed at, `close`, `nextOpen` or `midRange`")
+	slippage := fs.Float64("slippage", 0.0, "slippage including half the spread in basis points paid on every trade")
+	realValues := fs.Bool("real", false, "also show values in money of the start date and the IRR after inflation")
+	rolling := fs.Int("rolling", 0, "simulate every window of this many years and show the distribution of outcomes")
+	baseline := fs.String("baseline", "", "strategy the win rates of -rolling and -montecarlo are relative to, defaults to the first MidMonth strategy")
+	monteCarlo := fs.String("montecarlo", "", "simulate on synthetic prices of the model `bootstrap`, `gbm` or `garch`")
+	paths := fs.Int("paths", analyze.DefaultPaths, "number of synthetic price paths of -montecarlo")
+	years := fs.Int("years", analyze.DefaultMonteCarloYears, "length of the synthetic price paths in years")
+	seed := fs.Int64("seed", 0, "seed of the first synthetic price path")
+	block := fs.Int("block", 0, "length of the blocks of the bootstrap in trading days")
+	ledger := fs.Bool("ledger", false, "write the transactions of every strategy instead of the results")
+	strategy := fs.String("strategy", "", "only write the transactions of the strategy with this name")
+	format := fs.String("format", "table", "output format, `table`, `csv` or `json`")
+	out := fs.String("out", "", "file to write the results to instead of stdout")
+	csvDir := fs.String("csv", os.Getenv("FINCA_CSV_DIR"), "directory with CSV price files")
+	fs.Parse(args)
+
+	if *format != "table" && *format != "csv" && *format != "json" {
+		return fmt.Errorf("Unknown format %q", *format)
+	}
+	if *ledger && (*monteCarlo != "" || *rolling > 0) {
+		return errors.New("-ledger is not supported with -montecarlo or -rolling")
+	}
+
+	sc := sim.Scenario{Strategies: analyze.CompareSpecs}
+	if *scenarioFile != "" {
+		var err error
+		if sc, err = sim.LoadScenario(*scenarioFile); err != nil {
+			return err
+		}
+	}
+
+	// Flags given explicitly override the scenario. The errors of all of
+	// them are returned together.
+	var errs []string
+	fs.Visit(func(f *flag.Flag) {
+		var err error
+		switch f.Name {
+		case "symbol":
+			// A single symbol replaces the portfolio of the scenario
+			sc.Symbol = *symbol
+			sc.Portfolio = nil
+		case "symbols":
+			if sc.Portfolio, err = sim.ParseAllocations(*symbols); err == nil && !isFlagSet(fs, "symbol") {
+				sc.Symbol = ""
+			}
+		case "from":
+			sc.From = *from
+		case "to":
+			sc.To = *to
+		case "income":
+			if sc.Income == nil {
+				sc.Income = &sim.IncomeSpec{}
+			}
+			sc.Income.Monthly = *income
+			_, err = sc.Income.Build(time.Time{})
+		case "rebalance", "band":
+			spec := sim.RebalanceSpec{Mode: *rebalance, Band: *band}
+			if sc.Rebalance != nil {
+				// The flag not given keeps its setting of the scenario
+				if !isFlagSet(fs, "rebalance") {
+					spec.Mode = sc.Rebalance.Mode
+				}
+				if !isFlagSet(fs, "band") {
+					spec.Band = sc.Rebalance.Band
+				}
+			}
+			if spec.Mode == "" {
+				err = errors.New("No rebalance mode given")
+				break
+			}
+			sc.Rebalance = &spec
+			_, err = spec.Option()
+		case "fractional":
+			sc.Fractional = *fractional
+		case "dividends":
+			sc.Dividends = *dividends
+			_, err = sim.ParseDividendMode(sc.Dividends)
+		case "real":
+			sc.Real = *realValues
+		case "currency":
+			sc.Currency = *currency
+			err = sim.ValidateCurrency(sc.Currency)
+		case "transactions":
+			sc.Transactions = *transactions
+		case "execution", "slippage":
+			sc.Execution = &sim.ExecutionSpec{Price: *execution, Slippage: *slippage}
+			_, err = sc.Execution.Model()
+		case "gainsTax", "dividendTax", "withdrawalTax", "taxAllowance", "lots", "account":
+			sc.Tax = &sim.TaxSpec{
+				CapitalGains: *gainsTax,
+				Dividends:    *dividendTax,
+				Withdrawals:  *withdrawalTax,
+				Allowance:    *taxAllowance,
+				Lots:         *lots,
+				Account:      *account,
+			}
+			_, err = sc.Tax.Option()
+		case "fixedFees", "varFees", "minFees", "maxFees", "feeType":
+			sc.Fees = feesFromFlags(fs, *feeType, map[string]float64{
+				"fixedFees": *fixedFees,
+				"varFees":   *varFees,
+				"minFees":   *minFees,
+				"maxFees":   *maxFees,
+			})
+			_, err = sc.Fees.Model()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("-%s: %v", f.Name, err))
+		}
+	})
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	priceP, err := launchProvider(*csvDir)
+	if err != nil {
+		return err
+	}
+	defer av.SaveCache()
+
+	ctx := context.Background()
+	var title string
+	var header []string
+	var rows [][]string
+	// Written instead of the rows with -format json
+	var result interface{}
+	if *monteCarlo != "" {
+		mc := analyze.MonteCarloSpec{
+			Returns: synth.Spec{Model: *monteCarlo, Block: *block},
+			Paths:   *paths,
+			Years:   *years,
+			Seed:    *seed,
+		}
+		res, err := analyze.RunMonteCarlo(ctx, priceP, sc, mc, *baseline)
+		if err != nil {
+			return err
+		}
+		title = fmt.Sprintf("%s on %d %s paths of %d years fitted to %s to %s",
+			resultTitle(res.Portfolio, res.Currency), res.Paths, res.Model, *years, res.From, res.To)
+		header, rows = outcomeRows(sc, res.Stats, res.Baseline)
+		result = res
+	} else if *rolling > 0 {
+		res, err := analyze.RunRolling(ctx, priceP, sc, *rolling, *baseline)
+		if err != nil {
+			return err
+		}
+		title = fmt.Sprintf("%s in %d windows of %d years starting %s to %s",
+			resultTitle(res.Portfolio, res.Currency), len(res.Starts), res.Years, res.Starts[0], res.Starts[len(res.Starts)-1])
+		header, rows = outcomeRows(sc, res.Stats, res.Baseline)
+		result = res
+	} else {
+		res, err := analyze.RunScenario(ctx, priceP, sc)
+		if err != nil {
+			return err
+		}
+		title = fmt.Sprintf("%s from %s to %s", resultTitle(res.Portfolio, res.Currency), res.From, res.To)
+		header, rows = resultRows(res)
+		result = res
+		if *ledger {
+			ledgers, err := analyze.ScenarioLedgers(res, *strategy)
+			if err != nil {
+				return err
+			}
+			title = "Transactions of " + title
+			header, rows = analyze.LedgerHeader, analyze.LedgerRecords(ledgers)
+			result = ledgers
+		}
+	}
+	if sc.Execution != nil && *sc.Execution != (sim.ExecutionSpec{}) {
+		title += ", trading at " + sc.Execution.String()
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "csv":
+		return writeCSV(w, header, rows)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	return writeTable(w, title, header, rows)
+}
+
+// feesFromFlags creates a fee spec of the fee type `feeType` from the `values`
+// of the fee flags. The flags given explicitly override the
+// `analyze.DefaultFees` of the fee type like the parameters of a request.
+func feesFromFlags(fs *flag.FlagSet, feeType string, values map[string]float64) *sim.FeeSpec {
+	if feeType == "" && (isFlagSet(fs, "minFees") || isFlagSet(fs, "maxFees")) {
+		feeType = "percentage"
+	}
+	fees := analyze.DefaultFees[feeType]
+	fees.Type = feeType
+	for name, field := range map[string]*float64{
+		"fixedFees": &fees.Fixed,
+		"varFees":   &fees.Var,
+		"minFees":   &fees.Min,
+		"maxFees":   &fees.Max,
+	} {
+		if isFlagSet(fs, name) {
+			*field = values[name]
+		}
+	}
+	return &fees
+}
+
+// isFlagSet tells if the flag `name` was given explicitly.
+func isFlagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
+
+// resultRows returns the header and one row per strategy in the order of the
+// scenario, followed by the real portfolio of its transactions. Real values
+// are added if the scenario was simulated with them.
+func resultRows(res analyze.ScenarioResult) (header []string, rows [][]string) {
+	header = []string{"Strategy", "Paid in", "Withdrawn", "Dividends", "Final value", "IRR [%]",
+		"Fees paid", "Taxes paid", "After tax", "IRR after tax [%]", "Depleted on"}
+	for _, name := range res.Names() {
+		stratRes := res.Results[name]
+		depleted := stratRes.DepletedOn
+		if depleted == "" {
+			depleted = "-"
+		}
+		row := []string{
+			name,
+			strconv.FormatFloat(stratRes.PaidIn, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.Withdrawn, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.Dividends, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.FinalValue, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.IRR, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.Fees, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.Taxes, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.FinalValueAfterTax, 'f', 2, 64),
+			strconv.FormatFloat(stratRes.IRRAfterTax, 'f', 2, 64),
+			depleted,
+		}
+		if res.Real {
+			row = append(row,
+				strconv.FormatFloat(stratRes.RealFinalValue, 'f', 2, 64),
+				strconv.FormatFloat(stratRes.RealIRR, 'f', 2, 64),
+			)
+		}
+		rows = append(rows, row)
+	}
+	if res.Real {
+		header = append(header, "Real value", "Real IRR [%]")
+	}
+	return
+}
+
+// outcomeRows returns the header and one row per strategy in the order of
+// the scenario with the distribution of its outcomes over all windows or
+// paths.
+func outcomeRows(sc sim.Scenario, outcomes map[string]analyze.OutcomeStats, baseline string) (header []string, rows [][]string) {
+	header = []string{"Strategy", "Min. IRR [%]", "10th perc. [%]", "Median IRR [%]", "90th perc. [%]",
+		"Max. IRR [%]", "Mean IRR [%]", "Median final value", "Wins vs. " + baseline + " [%]"}
+	for _, spec := range sc.Strategies {
+		name := spec.DisplayName()
+		stats := outcomes[name]
+		winRate := strconv.FormatFloat(stats.WinRate, 'f', 2, 64)
+		if name == baseline {
+			winRate = "-"
+		}
+		rows = append(rows, []string{
+			name,
+			strconv.FormatFloat(stats.IRR.Min, 'f', 2, 64),
+			strconv.FormatFloat(stats.IRR.P10, 'f', 2, 64),
+			strconv.FormatFloat(stats.IRR.Median, 'f', 2, 64),
+			strconv.FormatFloat(stats.IRR.P90, 'f', 2, 64),
+			strconv.FormatFloat(stats.IRR.Max, 'f', 2, 64),
+			strconv.FormatFloat(stats.IRR.Mean, 'f', 2, 64),
+			strconv.FormatFloat(stats.FinalValue.Median, 'f', 2, 64),
+			winRate,
+		})
+	}
+	return
+}
+
+// resultTitle describes the portfolio of a result in `currency`.
+func resultTitle(portfolio []sim.Allocation, currency string) string {
+	title := sim.FormatAllocations(portfolio)
+	if currency != "" {
+		title += " in " + currency
+	}
+	return title
+}
+
+func writeTable(w io.Writer, title string, header []string, rows [][]string) error {
+	fmt.Fprintf(w, "%s\n\n", title)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, row := range append([][]string{header}, rows...) {
+		for _, cell := range row {
+			fmt.Fprint(tw, cell, "\t")
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, header []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
@@ -0,0 +1,189 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// A Generator generates daily log returns of the symbols of a history.
+type Generator interface {
+	// Generate returns the log returns of all symbols on `days` trading
+	// days.
+	Generate(rng *rand.Rand, days int) [][]float64
+}
+
+// A Model determines how synthetic returns are generated from a history.
+type Model int
+
+const (
+	// Bootstrap draws blocks of consecutive historic returns of all symbols.
+	// This keeps the fat tails, the correlations and the short-term
+	// dependencies of the history.
+	Bootstrap Model = iota
+	// GBM draws normally distributed returns with the mean and covariance of
+	// the history, the geometric Brownian motion.
+	GBM
+	// GARCH draws correlated returns of which the variance of every symbol
+	// follows a GARCH(1,1) process with the variance of the history in the
+	// long run. This models the clustering of volatility.
+	GARCH
+)
+
+var modelNames = map[Model]string{
+	Bootstrap: "bootstrap",
+	GBM:       "gbm",
+	GARCH:     "garch",
+}
+
+func (model Model) String() string {
+	return modelNames[model]
+}
+
+// ParseModel returns the model named `bootstrap`, `gbm` or `garch`. An empty
+// name is the model `Bootstrap`.
+func ParseModel(name string) (Model, error) {
+	if name == "" {
+		return Bootstrap, nil
+	}
+	for model, modelName := range modelNames {
+		if modelName == name {
+			return model, nil
+		}
+	}
+	return Bootstrap, fmt.Errorf("Unknown model %q", name)
+}
+
+var (
+	// DefaultBlock is the length of the blocks of the bootstrap in trading
+	// days.
+	DefaultBlock = 20
+	// DefaultAlpha and DefaultBeta are the parameters of the GARCH(1,1)
+	// model, typical for stock indices.
+	DefaultAlpha = 0.1
+	DefaultBeta  = 0.88
+)
+
+// A Spec describes the model of synthetic returns. Parameters not given fall
+// back to their defaults.
+type Spec struct {
+	// One of `bootstrap` (default), `gbm` or `garch`
+	Model string
+	// Length of the blocks of the bootstrap in trading days
+	Block int
+	// Weight of the last squared return and of the last variance in the
+	// variance of the GARCH(1,1) model
+	Alpha float64
+	Beta  float64
+}
+
+// Generator returns the generator described by the spec, fitted to `h`.
+func (spec Spec) Generator(h *History) (Generator, error) {
+	model, err := ParseModel(spec.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	switch model {
+	case GBM:
+		chol, err := cholesky(h.covariance())
+		if err != nil {
+			return nil, err
+		}
+		return &gbm{mean: h.mean(), chol: chol}, nil
+	case GARCH:
+		alpha, beta := spec.Alpha, spec.Beta
+		if alpha == 0.0 && beta == 0.0 {
+			alpha, beta = DefaultAlpha, DefaultBeta
+		}
+		if alpha < 0.0 || beta < 0.0 || alpha+beta >= 1.0 {
+			return nil, fmt.Errorf("GARCH parameters %v and %v must be positive with a sum below 1", alpha, beta)
+		}
+
+		cov := h.covariance()
+		variance := make([]float64, len(cov))
+		corr := make([][]float64, len(cov))
+		for i := range cov {
+			variance[i] = cov[i][i]
+			corr[i] = make([]float64, len(cov))
+			for j := range cov {
+				corr[i][j] = cov[i][j] / math.Sqrt(cov[i][i]*cov[j][j])
+			}
+		}
+		chol, err := cholesky(corr)
+		if err != nil {
+			return nil, err
+		}
+		return &garch{mean: h.mean(), variance: variance, chol: chol, alpha: alpha, beta: beta}, nil
+	}
+
+	block := spec.Block
+	if block == 0 {
+		block = DefaultBlock
+	}
+	if block < 1 || block > len(h.Returns) {
+		return nil, fmt.Errorf("Block length %d not between 1 and %d", block, len(h.Returns))
+	}
+	return &blockBootstrap{returns: h.Returns, block: block}, nil
+}
+
+// blockBootstrap draws blocks of consecutive returns, wrapping around at the
+// end of the history.
+type blockBootstrap struct {
+	returns [][]float64
+	block   int
+}
+
+func (b *blockBootstrap) Generate(rng *rand.Rand, days int) [][]float64 {
+	out := make([][]float64, 0, days)
+	for len(out) < days {
+		start := rng.Intn(len(b.returns))
+		for i := 0; i < b.block && len(out) < days; i++ {
+			out = append(out, b.returns[(start+i)%len(b.returns)])
+		}
+	}
+	return out
+}
+
+type gbm struct {
+	mean []float64
+	// Cholesky factor of the covariance of the returns
+	chol [][]float64
+}
+
+func (g *gbm) Generate(rng *rand.Rand, days int) [][]float64 {
+	out := make([][]float64, days)
+	for d := range out {
+		out[d] = correlatedNormals(rng, g.chol)
+		for i := range out[d] {
+			out[d][i] += g.mean[i]
+		}
+	}
+	return out
+}
+
+type garch struct {
+	mean []float64
+	// Long-run variance of the returns of every symbol
+	variance []float64
+	// Cholesky factor of the correlation of the returns
+	chol        [][]float64
+	alpha, beta float64
+}
+
+func (g *garch) Generate(rng *rand.Rand, days int) [][]float64 {
+	// Start in the long-run state
+	variance := append([]float64(nil), g.variance...)
+
+	out := make([][]float64, days)
+	for d := range out {
+		out[d] = correlatedNormals(rng, g.chol)
+		for i, z := range out[d] {
+			shock := math.Sqrt(variance[i]) * z
+			out[d][i] = g.mean[i] + shock
+			omega := g.variance[i] * (1.0 - g.alpha - g.beta)
+			variance[i] = omega + g.alpha*shock*shock + g.beta*variance[i]
+		}
+	}
+	return out
+}
@@ -0,0 +1,214 @@
+package synth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/sgasse/finca/sim"
+)
+
+// A History holds the daily log returns of symbols on the dates for which
+// prices of all of them are known.
+type History struct {
+	Symbols []string
+	// Log returns of all symbols from one date to the next
+	Returns [][]float64
+	// Prices of all symbols on the last date
+	Last []float64
+}
+
+// NewHistory aligns the prices of `series`, given by symbol and date as
+// `2006-01-02`, on the dates common to all symbols.
+func NewHistory(series map[string]map[string]float64) (*History, error) {
+	h := &History{}
+	for symbol := range series {
+		h.Symbols = append(h.Symbols, symbol)
+	}
+	if len(h.Symbols) == 0 {
+		return nil, errors.New("No symbols given")
+	}
+	sort.Strings(h.Symbols)
+
+	var dates []string
+	for date := range series[h.Symbols[0]] {
+		common := true
+		for _, symbol := range h.Symbols[1:] {
+			if _, ok := series[symbol][date]; !ok {
+				common = false
+				break
+			}
+		}
+		if common {
+			dates = append(dates, date)
+		}
+	}
+	if len(dates) < 3 {
+		return nil, errors.New("Not enough common prices of the symbols")
+	}
+	sort.Strings(dates)
+
+	prev := make([]float64, len(h.Symbols))
+	for d, date := range dates {
+		prices := make([]float64, len(h.Symbols))
+		for i, symbol := range h.Symbols {
+			prices[i] = series[symbol][date]
+			if prices[i] <= 0 {
+				return nil, fmt.Errorf("Price of %s on %s must be positive", symbol, date)
+			}
+		}
+
+		if d > 0 {
+			returns := make([]float64, len(h.Symbols))
+			for i := range prices {
+				returns[i] = math.Log(prices[i] / prev[i])
+			}
+			h.Returns = append(h.Returns, returns)
+		}
+		prev = prices
+	}
+	h.Last = prev
+	return h, nil
+}
+
+// mean returns the mean log return of every symbol.
+func (h *History) mean() []float64 {
+	mean := make([]float64, len(h.Symbols))
+	for _, returns := range h.Returns {
+		for i, r := range returns {
+			mean[i] += r / float64(len(h.Returns))
+		}
+	}
+	return mean
+}
+
+// covariance returns the covariance matrix of the log returns.
+func (h *History) covariance() [][]float64 {
+	mean := h.mean()
+	n := len(h.Symbols)
+	cov := make([][]float64, n)
+	for i := range cov {
+		cov[i] = make([]float64, n)
+	}
+	for _, returns := range h.Returns {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				cov[i][j] += (returns[i] - mean[i]) * (returns[j] - mean[j]) / float64(len(h.Returns)-1)
+			}
+		}
+	}
+	return cov
+}
+
+// cholesky returns the lower triangular matrix `l` with `l * l^T = m` of
+// the symmetric positive definite matrix `m`.
+func cholesky(m [][]float64) ([][]float64, error) {
+	n := len(m)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+	}
+
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			sum := m[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, errors.New("Returns of the symbols are linearly dependent")
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// correlatedNormals draws standard normal values correlated by the Cholesky
+// factor `l`.
+func correlatedNormals(rng *rand.Rand, l [][]float64) []float64 {
+	z := make([]float64, len(l))
+	for i := range z {
+		z[i] = rng.NormFloat64()
+	}
+
+	x := make([]float64, len(l))
+	for i := range l {
+		for j := 0; j <= i; j++ {
+			x[i] += l[i][j] * z[j]
+		}
+	}
+	return x
+}
+
+// A Provider provides the prices of one synthetic path of the symbols of a
+// history. Prices start at the last historic prices and change on every
+// weekday.
+type Provider struct {
+	start time.Time
+	end   time.Time
+	index map[string]int
+	// Prices of all symbols on every day from the start
+	prices [][]float64
+}
+
+// NewProvider generates a path of prices from `start` up to and including
+// `end` with the returns of `gen`. Paths are reproducible by their `seed`.
+func NewProvider(h *History, gen Generator, start time.Time, end time.Time, seed int64) *Provider {
+	p := &Provider{start: start, end: end, index: make(map[string]int)}
+	for i, symbol := range h.Symbols {
+		p.index[symbol] = i
+	}
+
+	days := int(end.Sub(start)/(24*time.Hour)) + 1
+	cal := sim.WeekdayCalendar{}
+	weekdays := 0
+	for d := 1; d < days; d++ {
+		if cal.IsTradingDay(start.AddDate(0, 0, d)) {
+			weekdays++
+		}
+	}
+	returns := gen.Generate(rand.New(rand.NewSource(seed)), weekdays)
+
+	prices := append([]float64(nil), h.Last...)
+	p.prices = append(p.prices, prices)
+	for d := 1; d < days; d++ {
+		if cal.IsTradingDay(start.AddDate(0, 0, d)) {
+			next := make([]float64, len(prices))
+			for i, r := range returns[0] {
+				next[i] = prices[i] * math.Exp(r)
+			}
+			prices, returns = next, returns[1:]
+		}
+		p.prices = append(p.prices, prices)
+	}
+	return p
+}
+
+func (p *Provider) GetPrice(symbol string, date time.Time) (float64, error) {
+	i, ok := p.index[symbol]
+	if !ok {
+		return 0.0, fmt.Errorf("No synthetic prices of %s", symbol)
+	}
+
+	day := int(math.Floor(date.Sub(p.start).Hours() / 24))
+	if day < 0 || day >= len(p.prices) {
+		return 0.0, fmt.Errorf("No synthetic price of %s on %s", symbol, date.Format("2006-01-02"))
+	}
+	return p.prices[day][i], nil
+}
+
+func (p *Provider) GetDateRange(symbol string) (earliest, latest string, err error) {
+	if _, ok := p.index[symbol]; !ok {
+		err = fmt.Errorf("No synthetic prices of %s", symbol)
+		return
+	}
+	return p.start.Format("2006-01-02"), p.end.Format("2006-01-02"), nil
+}
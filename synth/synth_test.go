@@ -0,0 +1,109 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testHistory returns a history of two symbols with alternating returns.
+func testHistory(t *testing.T) *History {
+	a := map[string]float64{}
+	b := map[string]float64{}
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		d := date.AddDate(0, 0, i).Format("2006-01-02")
+		a[d] = 100.0 * math.Pow(1.01, float64(i%2)) * math.Pow(1.001, float64(i))
+		b[d] = 50.0 * math.Pow(0.99, float64(i%3))
+	}
+	// Dates missing for one symbol are skipped
+	a["2019-12-31"] = 1.0
+
+	h, err := NewHistory(map[string]map[string]float64{"A": a, "B": b})
+	assert.Nil(t, err)
+	return h
+}
+
+func TestNewHistory(t *testing.T) {
+	h := testHistory(t)
+	assert.Equal(t, []string{"A", "B"}, h.Symbols)
+	assert.Equal(t, 99, len(h.Returns))
+	assert.InDelta(t, math.Log(1.01*1.001), h.Returns[0][0], 1e-12)
+	assert.InDelta(t, 50.0, h.Last[1], 1e-9)
+
+	_, err := NewHistory(map[string]map[string]float64{"A": {"2020-01-01": 1.0}})
+	assert.NotNil(t, err)
+}
+
+func TestProviderReproducible(t *testing.T) {
+	h := testHistory(t)
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	for _, model := range []string{"bootstrap", "gbm", "garch"} {
+		gen, err := Spec{Model: model}.Generator(h)
+		assert.Nil(t, err, model)
+
+		p1 := NewProvider(h, gen, start, end, 42)
+		p2 := NewProvider(h, gen, start, end, 42)
+		p3 := NewProvider(h, gen, start, end, 43)
+
+		price, err := p1.GetPrice("A", start)
+		assert.Nil(t, err)
+		assert.Equal(t, h.Last[0], price, "Paths should start at the last price")
+
+		price1, _ := p1.GetPrice("B", end)
+		price2, _ := p2.GetPrice("B", end)
+		price3, _ := p3.GetPrice("B", end)
+		assert.Equal(t, price1, price2, model)
+		assert.NotEqual(t, price1, price3, model)
+
+		// 2021-01-02 is a Saturday
+		sat, _ := p1.GetPrice("A", start.AddDate(0, 0, 1))
+		fri, _ := p1.GetPrice("A", start)
+		assert.Equal(t, fri, sat, "Prices should not change on weekends")
+
+		_, err = p1.GetPrice("A", end.AddDate(0, 0, 1))
+		assert.NotNil(t, err)
+		_, err = p1.GetPrice("C", start)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestBootstrapBlocks(t *testing.T) {
+	h := testHistory(t)
+	gen, err := Spec{Block: 5}.Generator(h)
+	assert.Nil(t, err)
+
+	returns := gen.Generate(rand.New(rand.NewSource(1)), 50)
+	assert.Equal(t, 50, len(returns))
+	for _, r := range returns {
+		assert.Contains(t, h.Returns, r, "Bootstrap should only draw historic returns")
+	}
+
+	_, err = Spec{Block: 1000}.Generator(h)
+	assert.NotNil(t, err)
+	_, err = Spec{Model: "garch", Alpha: 0.5, Beta: 0.6}.Generator(h)
+	assert.NotNil(t, err)
+	_, err = Spec{Model: "arima"}.Generator(h)
+	assert.NotNil(t, err)
+}
+
+func TestGBMMoments(t *testing.T) {
+	h := testHistory(t)
+	gen, err := Spec{Model: "gbm"}.Generator(h)
+	assert.Nil(t, err)
+
+	synthetic := &History{Symbols: h.Symbols, Returns: gen.Generate(rand.New(rand.NewSource(1)), 200000)}
+	mean, cov := h.mean(), h.covariance()
+	synthMean, synthCov := synthetic.mean(), synthetic.covariance()
+	for i := range mean {
+		assert.InDelta(t, mean[i], synthMean[i], 1e-4)
+		for j := range mean {
+			assert.InDelta(t, cov[i][j], synthCov[i][j], 1e-5)
+		}
+	}
+}